@@ -3,14 +3,21 @@ package main
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/caitong93/kube-extra-exporter/pkg/apis"
 	"github.com/caitong93/kube-extra-exporter/pkg/apis/filters"
 	"github.com/caitong93/kube-extra-exporter/pkg/apis/modifiers"
+	"github.com/caitong93/kube-extra-exporter/pkg/graph"
 	"github.com/caitong93/kube-extra-exporter/pkg/manager"
 	"github.com/caitong93/kube-extra-exporter/pkg/metrics"
+	"github.com/caitong93/kube-extra-exporter/pkg/network"
 	"github.com/caitong93/kube-extra-exporter/pkg/pod"
+	"github.com/caitong93/kube-extra-exporter/pkg/resolver"
 	"github.com/caitong93/kube-extra-exporter/pkg/version"
 
 	"github.com/caicloud/nirvana"
@@ -32,27 +39,9 @@ func main() {
 	// Create nirvana command.
 	cmd := config.NewNamedNirvanaCommand("server", config.NewDefaultOption())
 
-	nodeName := mustGetNodeName()
-	log.Infoln("Node name", nodeName)
-
-	restCfg, err := clientcmd.BuildConfigFromFlags("", "")
-	if err != nil {
-		log.Fatal(err)
-	}
-	podLister := pod.NewLister(context.Background(), kubernetes.NewForConfigOrDie(restCfg), nodeName)
-
-	// Init manager and prometheus collector.
-	manager, err := manager.New(podLister)
-	if err != nil {
-		log.Fatalln("Err create manager:", err)
-	}
-	go func() {
-		// FIXME: graceful terminate
-		if err := manager.Run(context.Background()); err != nil {
-			log.Fatal("Err run manager:", err)
-		}
-	}()
-	prometheus.MustRegister(metrics.NewPrometheusCollector(manager))
+	// Add exporter options.
+	opts := newDefaultOptions()
+	cmd.AddOption("exporter", opts)
 
 	// Create plugin options.
 	metricsOption := metricsplugin.NewDefaultOption() // Metrics plugin.
@@ -79,11 +68,67 @@ func main() {
 		nirvana.Descriptor(apis.Descriptor()),
 	)
 
+	// Everything is stopped once root context is cancelled on SIGTERM.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+		sig := <-sigs
+		log.Infof("Received %v, shutting down", sig)
+		cancel()
+	}()
+
+	var managerStopped <-chan struct{}
+	served := make(chan struct{})
+	drained := make(chan struct{})
+
 	// Set nirvana command hooks.
 	cmd.SetHook(&config.NirvanaCommandHookFunc{
+		PreConfigureFunc: func(config *nirvana.Config) error {
+			// Options are available now, start exporting.
+			if err := opts.validate(); err != nil {
+				return err
+			}
+			managerStopped = startExporter(ctx, opts)
+			return nil
+		},
 		PreServeFunc: func(config *nirvana.Config, server nirvana.Server) error {
 			// Output project information.
 			config.Logger().Infof("Package:%s Version:%s Commit:%s", version.Package, version.Version, version.Commit)
+
+			// Stop accepting scrapes on shutdown and wait for in-flight ones.
+			go func() {
+				defer close(drained)
+				<-ctx.Done()
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), opts.ShutdownTimeout)
+				defer cancel()
+				if err := server.Shutdown(shutdownCtx); err != nil {
+					log.Errorf("Err drain in-flight scrapes: %v", err)
+				}
+				// Shutdown does nothing if the server is not created yet,
+				// e.g. on SIGTERM during startup, it would serve forever.
+				select {
+				case <-served:
+				case <-shutdownCtx.Done():
+					log.Warningf("Server is not stopped in %v, exiting", opts.ShutdownTimeout)
+					os.Exit(1)
+				}
+			}()
+			return nil
+		},
+		PostServeFunc: func(config *nirvana.Config, server nirvana.Server, err error) error {
+			close(served)
+			if err != http.ErrServerClosed {
+				return err
+			}
+			<-drained
+			select {
+			case <-managerStopped:
+			case <-time.After(opts.ShutdownTimeout):
+				log.Warningf("Manager is not stopped in %v", opts.ShutdownTimeout)
+			}
+			log.Infoln("Exporter stopped")
 			return nil
 		},
 	})
@@ -94,6 +139,70 @@ func main() {
 	}
 }
 
+// startExporter starts discovering pods until ctx is done, the returned
+// channel is closed once manager is stopped.
+func startExporter(ctx context.Context, opts *options) <-chan struct{} {
+	nodeName := mustGetNodeName()
+	log.Infoln("Node name", nodeName)
+
+	restCfg, err := clientcmd.BuildConfigFromFlags("", "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	kubeClient := kubernetes.NewForConfigOrDie(restCfg)
+	podLister := pod.NewLister(ctx, kubeClient, nodeName)
+
+	var remoteResolver resolver.Resolver
+	if opts.ResolveRemotes {
+		if opts.RemoteTopN <= 0 && !opts.ConnectionGraph {
+			log.Fatal("Resolving remotes requires remote top N to be positive or connection graph")
+		}
+		remoteResolver = resolver.New(ctx, kubeClient)
+	}
+
+	// Init manager and prometheus collector.
+	manager, err := manager.New(podLister, manager.Options{
+		Network: network.Options{
+			Backend:         opts.NetworkBackend,
+			IncludeLoopback: opts.IncludeLoopback,
+			TcpInfo:         opts.TcpInfo,
+			RemoteTopN:      opts.RemoteTopN,
+			Outbound:        opts.ConnectionGraph,
+		},
+		Resolver: remoteResolver,
+		Cgroup: manager.CgroupOptions{
+			Driver:        opts.CgroupDriver,
+			Root:          opts.CgroupRoot,
+			DisablePerQOS: !opts.CgroupsPerQos,
+			Controller:    opts.CgroupController,
+		},
+		PidSource:          opts.PidSource,
+		CRIEndpoint:        opts.CriEndpoint,
+		ExcludeHostNetwork: opts.ExcludeHostNetwork,
+		CollectInterval:    opts.CollectInterval,
+		CollectWorkers:     opts.CollectWorkers,
+		CollectTimeout:     opts.CollectTimeout,
+		ContainerSockets:   opts.ContainerSockets,
+		ProcessSockets:     opts.ProcessSockets,
+		MaxCommands:        opts.MaxCommands,
+	})
+	if err != nil {
+		log.Fatalln("Err create manager:", err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if err := manager.Run(ctx); err != nil && ctx.Err() == nil {
+			log.Fatal("Err run manager:", err)
+		}
+	}()
+	prometheus.MustRegister(metrics.NewPrometheusCollector(manager, opts.NetstatFields))
+	if opts.ConnectionGraph {
+		graph.SetSource(manager)
+	}
+	return stopped
+}
+
 func mustGetNodeName() string {
 	nodeName := os.Getenv("NODE_NAME")
 	if nodeName == "" {
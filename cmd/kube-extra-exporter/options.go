@@ -0,0 +1,59 @@
+package main
+
+import (
+	"time"
+
+	"github.com/caitong93/kube-extra-exporter/pkg/manager"
+	"github.com/caitong93/kube-extra-exporter/pkg/metrics"
+	"github.com/caitong93/kube-extra-exporter/pkg/network"
+)
+
+// options contains configurations of kube-extra-exporter, they are filled
+// from flags, ENV or config file by nirvana command.
+type options struct {
+	NetstatFields      []string      `desc:"Counters from /proc/net/snmp and /proc/net/netstat exported per pod, e.g. TcpRetransSegs"`
+	IncludeLoopback    bool          `desc:"Export traffic counters of loopback interfaces"`
+	NetworkBackend     string        `desc:"How tcp sockets are walked, proc or netlink (falls back to proc without privileges)"`
+	TcpInfo            bool          `desc:"Export distributions of tcp_info (rtt, cwnd, retransmits...) by listening port, requires netlink backend"`
+	RemoteTopN         int           `desc:"Export tcp connections of the top N remote endpoints of every pod, 0 disables it"`
+	ResolveRemotes     bool          `desc:"Label remote endpoints with the Pods, Services and Nodes owning them"`
+	ConnectionGraph    bool          `desc:"Serve graph of outbound connections of pods at /apis/v1/graph"`
+	CgroupDriver       string        `desc:"Cgroup driver of kubelet, cgroupfs or systemd, detected from host cgroups if empty"`
+	CgroupRoot         string        `desc:"Cgroup root of kubelet, same as its --cgroup-root"`
+	CgroupsPerQos      bool          `desc:"Whether kubelet creates cgroups of QoS classes and pods, same as its --cgroups-per-qos"`
+	CgroupController   string        `desc:"Cgroup v1 controller searched for pids, e.g. cpu, pids, memory or unified, unified is always used on cgroup v2"`
+	PidSource          string        `desc:"Where pids of pods are found, cgroup or cri (pod sandboxes from container runtime)"`
+	CriEndpoint        string        `desc:"Container runtime socket under host rootfs for cri pid source, detected if empty"`
+	ExcludeHostNetwork bool          `desc:"Skip hostNetwork pods, whose network stats are of the whole node"`
+	ShutdownTimeout    time.Duration `desc:"How long in-flight scrapes are waited for on SIGTERM before exiting"`
+	CollectInterval    time.Duration `desc:"How often stats of pods are collected in background, scrapes serve the latest collection"`
+	CollectWorkers     int           `desc:"How many pods are read in parallel, number of CPUs if not positive"`
+	CollectTimeout     time.Duration `desc:"How long reading a pod is waited for, pods timed out serve stats of last collection marked stale"`
+	ContainerSockets   bool          `desc:"Attribute tcp connections to containers by their fds in /proc/<pid>/fd, requires cgroup pid source"`
+	ProcessSockets     bool          `desc:"Attribute tcp connections to command names of processes in containers too, requires cgroup pid source"`
+	MaxCommands        int           `desc:"How many commands of a container owning the most sockets tcp connections are attributed to, the rest are counted as other"`
+}
+
+func newDefaultOptions() *options {
+	return &options{
+		NetstatFields:   metrics.DefaultNetstatFields,
+		NetworkBackend:  network.BackendProc,
+		CgroupRoot:      "/",
+		CgroupsPerQos:   true,
+		PidSource:       manager.PidSourceCgroup,
+		ShutdownTimeout: 10 * time.Second,
+		CollectInterval: manager.DefaultCollectInterval,
+		CollectTimeout:  manager.DefaultCollectTimeout,
+		MaxCommands:     manager.DefaultMaxCommands,
+	}
+}
+
+// validate checks options and removes duplicated netstat fields.
+func (o *options) validate() error {
+	fields, err := metrics.CheckNetstatFields(o.NetstatFields)
+	if err != nil {
+		return err
+	}
+	o.NetstatFields = fields
+	return nil
+}
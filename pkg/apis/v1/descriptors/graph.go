@@ -0,0 +1,33 @@
+package descriptors
+
+import (
+	"github.com/caitong93/kube-extra-exporter/pkg/graph"
+
+	def "github.com/caicloud/nirvana/definition"
+)
+
+func init() {
+	register([]def.Descriptor{{
+		Path:        "/graph",
+		Definitions: []def.Definition{getGraph, getGraphDOT},
+	},
+	}...)
+}
+
+var getGraph = def.Definition{
+	Method:      def.Get,
+	Summary:     "Get Graph",
+	Description: "Get graph of tcp connections from pods on this node to their remote endpoints",
+	Function:    graph.GetGraph,
+	Produces:    []string{def.MIMEJSON},
+	Results:     def.DataErrorResults("A graph of connections"),
+}
+
+var getGraphDOT = def.Definition{
+	Method:      def.Get,
+	Summary:     "Get Graph in DOT",
+	Description: "Get graph of tcp connections in Graphviz DOT language, requested with Accept: text/plain",
+	Function:    graph.GetGraphDOT,
+	Produces:    []string{def.MIMEText},
+	Results:     def.DataErrorResults("A graph of connections in DOT language"),
+}
@@ -0,0 +1,204 @@
+package graph
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/caitong93/kube-extra-exporter/pkg/info"
+	"github.com/caitong93/kube-extra-exporter/pkg/network"
+	"github.com/caitong93/kube-extra-exporter/pkg/resolver"
+
+	"github.com/caicloud/nirvana/errors"
+)
+
+// Node kinds in addition to resolver kinds.
+const (
+	KindLocalPod = "localpod"
+	KindWorkload = "workload"
+)
+
+// Graph is a directed graph from pods on this node to their remote endpoints.
+type Graph struct {
+	Nodes []Node `json:"nodes"`
+	Edges []Edge `json:"edges"`
+}
+
+// Node is a pod on this node or a remote endpoint.
+type Node struct {
+	ID        string `json:"id"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// Edge counts tcp connections from Source to Target by state.
+type Edge struct {
+	Source      string            `json:"source"`
+	Target      string            `json:"target"`
+	Connections map[string]uint64 `json:"connections"`
+}
+
+type statsLister interface {
+	ListStats() ([]*info.Stats, error)
+}
+
+var source statsLister
+
+// SetSource sets where graphs are built from, it must be called before serving.
+// Stats of s must have outbound connections, graph is disabled if not set.
+func SetSource(s statsLister) {
+	source = s
+}
+
+// GetGraph returns graph of pods on this node.
+func GetGraph(ctx context.Context) (*Graph, error) {
+	if source == nil {
+		return nil, errors.ServiceUnavailable.Error("connection graph is disabled, enable it with --exporter-connection-graph")
+	}
+	stats, err := source.ListStats()
+	if err != nil {
+		return nil, err
+	}
+	return Build(stats), nil
+}
+
+// GetGraphDOT returns graph of pods on this node in Graphviz DOT language.
+func GetGraphDOT(ctx context.Context) (string, error) {
+	g, err := GetGraph(ctx)
+	if err != nil {
+		return "", err
+	}
+	return g.DOT(), nil
+}
+
+// Build builds graph from outbound connections of stats, endpoints not
+// resolved are identified by ip.
+func Build(stats []*info.Stats) *Graph {
+	nodes := map[string]Node{}
+	edges := map[[2]string]*network.TcpStat{}
+
+	for _, s := range stats {
+		src := Node{
+			ID:        fmt.Sprintf("%s:%s/%s", KindLocalPod, s.Namespace, s.PodName),
+			Kind:      KindLocalPod,
+			Namespace: s.Namespace,
+			Name:      s.PodName,
+		}
+		nodes[src.ID] = src
+
+		if s.Network == nil {
+			continue
+		}
+		for i := range s.Network.Outbound {
+			remote := &s.Network.Outbound[i]
+			dst := targetNode(remote.IP, s.RemoteEndpoints[remote.IP])
+			nodes[dst.ID] = dst
+
+			key := [2]string{src.ID, dst.ID}
+			stat, ok := edges[key]
+			if !ok {
+				stat = &network.TcpStat{}
+				edges[key] = stat
+			}
+			stat.Add(&remote.Tcp)
+		}
+	}
+
+	g := &Graph{
+		Nodes: make([]Node, 0, len(nodes)),
+		Edges: make([]Edge, 0, len(edges)),
+	}
+	for _, n := range nodes {
+		g.Nodes = append(g.Nodes, n)
+	}
+	for key, stat := range edges {
+		edge := Edge{
+			Source:      key[0],
+			Target:      key[1],
+			Connections: map[string]uint64{},
+		}
+		for _, state := range stat.States() {
+			if state.Count > 0 {
+				edge.Connections[state.State] = state.Count
+			}
+		}
+		g.Edges = append(g.Edges, edge)
+	}
+
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].ID < g.Nodes[j].ID })
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].Source != g.Edges[j].Source {
+			return g.Edges[i].Source < g.Edges[j].Source
+		}
+		return g.Edges[i].Target < g.Edges[j].Target
+	})
+	return g
+}
+
+// targetNode groups remote endpoints by workload, service or node.
+func targetNode(ip string, endpoint resolver.Endpoint) Node {
+	switch {
+	case endpoint.Kind == resolver.KindPod && endpoint.Workload != "":
+		return Node{
+			ID:        fmt.Sprintf("%s:%s/%s", KindWorkload, endpoint.Namespace, endpoint.Workload),
+			Kind:      KindWorkload,
+			Namespace: endpoint.Namespace,
+			Name:      endpoint.Workload,
+		}
+	case endpoint.Kind == resolver.KindPod || endpoint.Kind == resolver.KindService:
+		return Node{
+			ID:        fmt.Sprintf("%s:%s/%s", resolver.KindService, endpoint.Namespace, endpoint.Service),
+			Kind:      resolver.KindService,
+			Namespace: endpoint.Namespace,
+			Name:      endpoint.Service,
+		}
+	case endpoint.Kind == resolver.KindNode:
+		return Node{
+			ID:   fmt.Sprintf("%s:%s", resolver.KindNode, endpoint.Node),
+			Kind: resolver.KindNode,
+			Name: endpoint.Node,
+		}
+	}
+	return Node{
+		ID:   fmt.Sprintf("%s:%s", resolver.KindExternal, ip),
+		Kind: resolver.KindExternal,
+		Name: ip,
+	}
+}
+
+// DOT formats graph in Graphviz DOT language, edges are labelled with
+// connection counts by state.
+func (g *Graph) DOT() string {
+	var buf bytes.Buffer
+	buf.WriteString("digraph connections {\n")
+	for _, n := range g.Nodes {
+		shape := "box"
+		if n.Kind == KindLocalPod {
+			shape = "ellipse"
+		}
+		label := n.Name
+		if n.Namespace != "" {
+			label = n.Namespace + "/" + n.Name
+		}
+		fmt.Fprintf(&buf, "  %q [label=%q, shape=%s];\n", n.ID, label, shape)
+	}
+	for _, e := range g.Edges {
+		states := make([]string, 0, len(e.Connections))
+		for state := range e.Connections {
+			states = append(states, state)
+		}
+		sort.Strings(states)
+		var label bytes.Buffer
+		for i, state := range states {
+			if i > 0 {
+				label.WriteString("\n")
+			}
+			fmt.Fprintf(&label, "%s=%d", state, e.Connections[state])
+		}
+		fmt.Fprintf(&buf, "  %q -> %q [label=%q];\n", e.Source, e.Target, label.String())
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
@@ -0,0 +1,56 @@
+package graph
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/caitong93/kube-extra-exporter/pkg/info"
+	"github.com/caitong93/kube-extra-exporter/pkg/network"
+	"github.com/caitong93/kube-extra-exporter/pkg/resolver"
+)
+
+func TestBuild(t *testing.T) {
+	stats := []*info.Stats{
+		{
+			PodName:   "web-7db9fccd9b-x2k4z",
+			Namespace: "default",
+			Network: &network.Stats{
+				Outbound: []network.RemoteStat{
+					{IP: "172.16.1.5", Port: 3306, Tcp: network.TcpStat{Established: 2}},
+					{IP: "172.16.1.6", Port: 3306, Tcp: network.TcpStat{Established: 1, TimeWait: 4}},
+					{IP: "8.8.8.8", Port: 53, Tcp: network.TcpStat{SynSent: 1}},
+				},
+			},
+			RemoteEndpoints: map[string]resolver.Endpoint{
+				"172.16.1.5": {Kind: resolver.KindPod, Namespace: "db", Workload: "mysql", Service: "mysql"},
+				"172.16.1.6": {Kind: resolver.KindPod, Namespace: "db", Workload: "mysql", Service: "mysql"},
+				"8.8.8.8":    {Kind: resolver.KindExternal},
+			},
+		},
+	}
+
+	expect := &Graph{
+		Nodes: []Node{
+			{ID: "external:8.8.8.8", Kind: resolver.KindExternal, Name: "8.8.8.8"},
+			{ID: "localpod:default/web-7db9fccd9b-x2k4z", Kind: KindLocalPod, Namespace: "default", Name: "web-7db9fccd9b-x2k4z"},
+			{ID: "workload:db/mysql", Kind: KindWorkload, Namespace: "db", Name: "mysql"},
+		},
+		Edges: []Edge{
+			{
+				Source:      "localpod:default/web-7db9fccd9b-x2k4z",
+				Target:      "external:8.8.8.8",
+				Connections: map[string]uint64{"synsent": 1},
+			},
+			{
+				Source:      "localpod:default/web-7db9fccd9b-x2k4z",
+				Target:      "workload:db/mysql",
+				Connections: map[string]uint64{"established": 3, "timewait": 4},
+			},
+		},
+	}
+
+	g := Build(stats)
+	if !reflect.DeepEqual(expect, g) {
+		t.Errorf("expect\n%+v,\ngot\n%+v", expect, g)
+	}
+}
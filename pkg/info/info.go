@@ -1,11 +1,139 @@
 package info
 
 import (
+	"time"
+
 	"github.com/caitong93/kube-extra-exporter/pkg/network"
+	"github.com/caitong93/kube-extra-exporter/pkg/resolver"
 )
 
+// DeclaredPort is a port in spec.containers[].ports of pod, Proto is "tcp",
+// "udp" or "sctp".
+type DeclaredPort struct {
+	Container string
+	Proto     string
+	Port      uint16
+}
+
 type Stats struct {
-	PodName string
+	PodName   string
+	Namespace string
+	// HostNetwork pods share stats of host network namespace.
+	HostNetwork bool
+	// CollectedAt is when stats of network namespace are read, scrapes serve
+	// the latest ones.
+	CollectedAt time.Time
+	// ProcessesCollectedAt is when stats of processes and cgroups are read,
+	// they are read apart from network namespace.
+	ProcessesCollectedAt time.Time
+	// Stale stats are of an earlier collection since reading pod timed out.
+	Stale bool
+	// Network is nil if network namespace of pod is not read, e.g. it's
+	// excluded hostNetwork one.
+	Network  *network.Stats
+	Counters network.Counters
+	// RemoteEndpoints are keyed by ip of Network.Remotes and Network.Outbound.
+	RemoteEndpoints map[string]resolver.Endpoint
+	DeclaredPorts   []DeclaredPort
+	// Containers are nil if pid source doesn't know containers of pod.
+	Containers []ContainerStats
+	// Commands are nil unless sockets are attributed to processes.
+	Commands []CommandStats
+	// Processes are of all containers, nil if none is readable.
+	Processes *ProcessStats
+	// Pids are of pod cgroup, nil if kubelet doesn't create cgroups of pods
+	// or pids cgroup is not found.
+	Pids *PidsCgroupStats
+	// Pressure is of pod cgroup, nil unless on cgroup v2 with PSI enabled.
+	Pressure []PressureStats
+}
+
+// ContainerStats are stats of a container of pod.
+type ContainerStats struct {
+	Name string
+	// Tcp are connections of sockets opened by processes of container, nil
+	// unless sockets are attributed to containers.
+	Tcp *network.TcpStat
+	// Fds are nil if no process of container is readable.
+	Fds *FdStats
+	// Processes are nil if no process of container is readable.
+	Processes *ProcessStats
+	// Pids are nil if pids cgroup of container is not found.
+	Pids *PidsCgroupStats
+}
+
+// FdStats are open files of processes of a container.
+type FdStats struct {
+	// Open is fds opened by all processes.
+	Open uint64
+	// SoftLimit and HardLimit are the lowest max open files of processes,
+	// +Inf if unlimited.
+	SoftLimit float64
+	HardLimit float64
+	// Utilisation is the highest ratio of open fds to soft limit of a
+	// process, which fails with EMFILE at 1.
+	Utilisation float64
+}
+
+// ProcessStats are processes of a container or pod.
+type ProcessStats struct {
+	Processes uint64
+	Threads   uint64
+	// Zombies are processes exited but not reaped by their parents.
+	Zombies uint64
+}
+
+// Add adds processes of other to s.
+func (s *ProcessStats) Add(other *ProcessStats) {
+	s.Processes += other.Processes
+	s.Threads += other.Threads
+	s.Zombies += other.Zombies
+}
+
+// PidsCgroupStats are of pids cgroup of a container or pod.
+type PidsCgroupStats struct {
+	// Current is pids.current, tasks in cgroup and its descendants.
+	Current uint64
+	// Max is pids.max, +Inf if unlimited.
+	Max float64
+}
+
+// PressureStats are a line of pressure stall information of a resource, e.g.
+// "some avg10=0.12 avg60=0.05 avg300=0.01 total=123456" of cpu.pressure.
+type PressureStats struct {
+	// Resource is cpu, memory or io.
+	Resource string
+	// Kind is some, tasks stalled partly, or full, all tasks stalled.
+	Kind string
+	// Avg10, Avg60 and Avg300 are percentages of time stalled in the
+	// last 10, 60 and 300 seconds.
+	Avg10  float64
+	Avg60  float64
+	Avg300 float64
+	// Total is microseconds stalled in total.
+	Total uint64
+}
+
+// CommandStats are stats of processes of a command in container.
+type CommandStats struct {
+	Container string
+	// Command is name of processes in /proc/<pid>/comm, or "other" for
+	// commands beyond the limit of container.
+	Command string
+	// Tcp are connections of sockets opened by the processes.
+	Tcp network.TcpStat
+}
+
+// DiscoveryError identifies failures discovering processes of a pod.
+type DiscoveryError struct {
+	Namespace string
+	PodName   string
+	// Reason is a short snake_case cause, e.g. cgroup_not_found.
+	Reason string
+}
+
+// PodRef identifies a pod on the node.
+type PodRef struct {
 	Namespace string
-	Network *network.Stats
-}
\ No newline at end of file
+	PodName   string
+}
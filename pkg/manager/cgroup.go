@@ -0,0 +1,339 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// cgroupMode is how cgroup hierarchies are mounted on host.
+type cgroupMode int
+
+const (
+	// cgroupV1 mounts a hierarchy for every controller, e.g. /sys/fs/cgroup/cpu.
+	cgroupV1 cgroupMode = iota
+	// cgroupV2 mounts the unified hierarchy at /sys/fs/cgroup.
+	cgroupV2
+)
+
+func (m cgroupMode) String() string {
+	if m == cgroupV2 {
+		return "v2"
+	}
+	return "v1"
+}
+
+// detectCgroupMode checks for cgroup.controllers, which only exists in root
+// of the unified hierarchy.
+func detectCgroupMode(rootFs string) cgroupMode {
+	if _, err := os.Stat(path.Join(rootFs, "/sys/fs/cgroup/cgroup.controllers")); err == nil {
+		return cgroupV2
+	}
+	return cgroupV1
+}
+
+// Cgroup drivers of kubelet.
+const (
+	CgroupDriverCgroupfs = "cgroupfs"
+	CgroupDriverSystemd  = "systemd"
+)
+
+// CgroupControllerUnified selects the unified hierarchy, which is
+// /sys/fs/cgroup on cgroup v2 and /sys/fs/cgroup/unified in hybrid mode.
+const CgroupControllerUnified = "unified"
+
+// CgroupOptions describes how kubelet lays out cgroups, fields mirror kubelet
+// flags of the same names.
+type CgroupOptions struct {
+	// Driver is CgroupDriverCgroupfs or CgroupDriverSystemd, it is detected
+	// from cgroups of host if empty.
+	Driver string
+	// Root is --cgroup-root of kubelet, "/" if empty.
+	Root string
+	// DisablePerQOS is set if kubelet runs with --cgroups-per-qos=false.
+	DisablePerQOS bool
+	// Controller is the hierarchy searched for pids, e.g. cpu, pids, memory or
+	// CgroupControllerUnified. It is cpu on cgroup v1 and unified on v2 if empty.
+	Controller string
+}
+
+// cgroupDriver converts cgroup names of kubelet, e.g. [kubepods burstable
+// pod<uid>], to paths the same way kubelet and container runtimes with the
+// same --cgroup-driver do.
+type cgroupDriver interface {
+	// cgroupPath returns path of cgroup name relative to hierarchy root.
+	cgroupPath(name []string) string
+	// parseCgroupRoot converts --cgroup-root of kubelet to a cgroup name.
+	parseCgroupRoot(root string) []string
+	// containerNames returns candidate names of container cgroup in pod cgroup,
+	// runtime is the cri prefix of container ID and may be empty.
+	containerNames(runtime, containerID string) []string
+}
+
+func newCgroupDriver(name string) (cgroupDriver, error) {
+	switch name {
+	case CgroupDriverCgroupfs:
+		return cgroupfsDriver{}, nil
+	case CgroupDriverSystemd:
+		return systemdDriver{}, nil
+	}
+	return nil, fmt.Errorf("unknown cgroup driver %q", name)
+}
+
+// cgroupfsDriver names cgroups like kubepods/burstable/pod<uid>/<id>.
+type cgroupfsDriver struct{}
+
+func (cgroupfsDriver) cgroupPath(name []string) string {
+	return path.Join(name...)
+}
+
+func (cgroupfsDriver) parseCgroupRoot(root string) []string {
+	name := []string{}
+	for _, part := range strings.Split(root, "/") {
+		if part != "" {
+			name = append(name, part)
+		}
+	}
+	return name
+}
+
+func (cgroupfsDriver) containerNames(runtime, containerID string) []string {
+	return []string{containerID}
+}
+
+// systemdDriver names cgroups like kubepods.slice/kubepods-burstable.slice/
+// kubepods-burstable-pod<uid>.slice/docker-<id>.scope, dashes in names are
+// replaced by underscores since dashes separate parents in slice names.
+type systemdDriver struct{}
+
+func (systemdDriver) cgroupPath(name []string) string {
+	parts := make([]string, 0, len(name))
+	prefix := ""
+	for _, part := range name {
+		prefix += strings.Replace(part, "-", "_", -1)
+		parts = append(parts, prefix+".slice")
+		prefix += "-"
+	}
+	return path.Join(parts...)
+}
+
+// parseCgroupRoot takes the innermost slice, e.g. /k8s.slice/k8s-node.slice
+// is [k8s node].
+func (systemdDriver) parseCgroupRoot(root string) []string {
+	slice := path.Base(root)
+	if slice == "/" || slice == "." || !strings.HasSuffix(slice, ".slice") {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(slice, ".slice"), "-")
+}
+
+// systemdScopePrefixes are prefixes of container scopes by cri prefix.
+var systemdScopePrefixes = map[string]string{
+	"docker":     "docker-",
+	"containerd": "cri-containerd-",
+	"cri-o":      "crio-",
+}
+
+func (systemdDriver) containerNames(runtime, containerID string) []string {
+	if prefix, ok := systemdScopePrefixes[runtime]; ok {
+		return []string{prefix + containerID + ".scope"}
+	}
+	names := []string{}
+	for _, prefix := range []string{"docker-", "cri-containerd-", "crio-"} {
+		names = append(names, prefix+containerID+".scope")
+	}
+	return names
+}
+
+// cgroupResolver finds cgroups of containers under host rootfs.
+type cgroupResolver struct {
+	mode       cgroupMode
+	driverName string
+	driver     cgroupDriver
+	// root is the hierarchy searched, e.g. /rootfs/sys/fs/cgroup/cpu.
+	root string
+	// rootName is cgroup name of --cgroup-root.
+	rootName []string
+	perQOS   bool
+	// pidsRoot is the hierarchy with pids controller, empty if not found.
+	pidsRoot string
+	// unifiedRoot is the unified hierarchy on cgroup v2, which has pressure
+	// stall information, empty on v1.
+	unifiedRoot string
+}
+
+// newCgroupResolver detects cgroup mode of host and the driver if it's not
+// configured.
+func newCgroupResolver(rootFs string, opts CgroupOptions) (*cgroupResolver, error) {
+	r := &cgroupResolver{
+		mode:   detectCgroupMode(rootFs),
+		perQOS: !opts.DisablePerQOS,
+	}
+
+	controller := opts.Controller
+	if controller == "" {
+		controller = "cpu"
+		if r.mode == cgroupV2 {
+			controller = CgroupControllerUnified
+		}
+	}
+	switch {
+	case r.mode == cgroupV2 && controller == CgroupControllerUnified:
+		r.root = path.Join(rootFs, "/sys/fs/cgroup")
+	case r.mode == cgroupV2:
+		return nil, fmt.Errorf("controller %v has no hierarchy of its own on cgroup v2", controller)
+	case controller == CgroupControllerUnified:
+		// Hybrid mode mounts unified hierarchy besides v1 ones.
+		r.mode = cgroupV2
+		r.root = path.Join(rootFs, "/sys/fs/cgroup/unified")
+	default:
+		r.root = path.Join(rootFs, "/sys/fs/cgroup", controller)
+	}
+	if _, err := os.Stat(r.root); err != nil {
+		return nil, fmt.Errorf("err find cgroup hierarchy of controller %v: %v", controller, err)
+	}
+
+	// Hybrid mode keeps pids controller in v1 hierarchies.
+	r.pidsRoot = path.Join(rootFs, "/sys/fs/cgroup/pids")
+	if detectCgroupMode(rootFs) == cgroupV2 {
+		r.pidsRoot = path.Join(rootFs, "/sys/fs/cgroup")
+		r.unifiedRoot = r.pidsRoot
+	}
+	if _, err := os.Stat(r.pidsRoot); err != nil {
+		r.pidsRoot = ""
+	}
+
+	r.driverName = opts.Driver
+	if r.driverName == "" {
+		driverName, err := r.detectDriver(opts.Root)
+		if err != nil {
+			return nil, err
+		}
+		r.driverName = driverName
+	}
+	driver, err := newCgroupDriver(r.driverName)
+	if err != nil {
+		return nil, err
+	}
+	r.driver = driver
+	r.rootName = driver.parseCgroupRoot(opts.Root)
+	return r, nil
+}
+
+// detectDriver checks which driver names the cgroup pods are put in, kubepods
+// or cgroup root without --cgroups-per-qos, as it exists in hierarchy. Pods
+// right in hierarchy root tell nothing, the driver must be set then.
+func (r *cgroupResolver) detectDriver(cgroupRoot string) (string, error) {
+	probed := false
+	for _, driverName := range []string{CgroupDriverSystemd, CgroupDriverCgroupfs} {
+		driver, _ := newCgroupDriver(driverName)
+		name := driver.parseCgroupRoot(cgroupRoot)
+		if r.perQOS {
+			name = append(name, "kubepods")
+		}
+		if len(name) == 0 {
+			continue
+		}
+		probed = true
+		if _, err := os.Stat(path.Join(r.root, driver.cgroupPath(name))); err == nil {
+			return driverName, nil
+		}
+	}
+	if !probed {
+		return "", fmt.Errorf("cgroup driver can't be detected without cgroups per QoS under cgroup root %v, set it explicitly", cgroupRoot)
+	}
+	return CgroupDriverCgroupfs, nil
+}
+
+// podCgroupName returns cgroup name of pod like kubelet, which puts containers
+// right in cgroup root without --cgroups-per-qos.
+func (r *cgroupResolver) podCgroupName(qos v1.PodQOSClass, podUID string) ([]string, error) {
+	name := append([]string{}, r.rootName...)
+	if !r.perQOS {
+		return name, nil
+	}
+
+	name = append(name, "kubepods")
+	switch qos {
+	case v1.PodQOSGuaranteed:
+	case v1.PodQOSBestEffort:
+		name = append(name, "besteffort")
+	case v1.PodQOSBurstable:
+		name = append(name, "burstable")
+	default:
+		return nil, fmt.Errorf("invalid qos %v", qos)
+	}
+	return append(name, "pod"+podUID), nil
+}
+
+// podPath returns path of pod cgroup in the hierarchy searched.
+func (r *cgroupResolver) podPath(qos v1.PodQOSClass, podUID string) (string, error) {
+	podName, err := r.podCgroupName(qos, podUID)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(r.root, r.driver.cgroupPath(podName)), nil
+}
+
+// pathIn maps path of cgroup in the hierarchy searched to hierarchy at root,
+// e.g. pidsRoot, empty if root is empty.
+func (r *cgroupResolver) pathIn(root, cgroupPath string) string {
+	if root == "" {
+		return ""
+	}
+	return path.Join(root, strings.TrimPrefix(cgroupPath, r.root))
+}
+
+func (r *cgroupResolver) containerPath(qos v1.PodQOSClass, podUID, runtime, containerID string) (string, error) {
+	podPath, err := r.podPath(qos, podUID)
+	if err != nil {
+		return "", err
+	}
+
+	var lastErr error
+	for _, name := range r.driver.containerNames(runtime, containerID) {
+		cPath := path.Join(podPath, name)
+		if _, err := os.Stat(cPath); err != nil {
+			lastErr = err
+			continue
+		}
+		return cPath, nil
+	}
+	return "", lastErr
+}
+
+// cgroupSource fills pids of containers from their cgroups.
+type cgroupSource struct {
+	cgroups *cgroupResolver
+}
+
+func (s *cgroupSource) renew() error {
+	return nil
+}
+
+func (s *cgroupSource) close() error {
+	return nil
+}
+
+func (s *cgroupSource) fill(po *v1.Pod, data *podData) error {
+	// Containers are right in cgroup root without cgroups of pods.
+	if s.cgroups.perQOS {
+		if podPath, err := s.cgroups.podPath(po.Status.QOSClass, string(po.UID)); err == nil {
+			data.pidsCgroup = s.cgroups.pathIn(s.cgroups.pidsRoot, podPath)
+			data.unifiedCgroup = s.cgroups.pathIn(s.cgroups.unifiedRoot, podPath)
+		}
+	}
+	for _, cont := range po.Status.ContainerStatuses {
+		// Container is not created yet, pod is discovered again once it is.
+		if cont.ContainerID == "" {
+			continue
+		}
+		if err := data.addContainer(s.cgroups, cont.Name, cont.ContainerID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
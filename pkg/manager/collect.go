@@ -0,0 +1,434 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/caicloud/nirvana/log"
+	"github.com/caitong93/kube-extra-exporter/pkg/info"
+	"github.com/caitong93/kube-extra-exporter/pkg/network"
+	"github.com/caitong93/kube-extra-exporter/pkg/resolver"
+)
+
+// runCollector collects stats every collectInterval until ctx is done, so
+// scrapes never wait for reading /proc.
+func (m *Manager) runCollector(ctx context.Context) {
+	m.collect()
+
+	ticker := time.NewTicker(m.collectInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.collect()
+		}
+	}
+}
+
+// collect reads stats of all discovered pods and publishes them as the
+// latest snapshot. Pods are copied out so discovery isn't blocked by reading.
+func (m *Manager) collect() {
+	m.containersLock.Lock()
+	pods := make([]*podData, 0, len(m.pods))
+	for _, pod := range m.pods {
+		pods = append(pods, pod)
+	}
+	m.containersLock.Unlock()
+
+	// Every network namespace is read once, however many pods share it.
+	netnsOf := map[*podData]string{}
+	netnsPods := map[string][]*podData{}
+	netnsPids := map[string]int{}
+	for _, pod := range pods {
+		if pod.hostNetwork && m.excludeHostNetwork {
+			continue
+		}
+
+		pid := pod.onePid()
+		if pid < 0 {
+			// Pids of pod are not discovered yet, e.g. its containers are
+			// starting.
+			continue
+		}
+		id, err := netnsID(pid)
+		if err != nil {
+			log.Errorf("err get network namespace of pod %v: %v", pod.Name, err)
+			continue
+		}
+		if _, ok := netnsPods[id]; !ok {
+			netnsPids[id] = pid
+		}
+		netnsPods[id] = append(netnsPods[id], pod)
+		netnsOf[pod] = id
+	}
+
+	// Processes and cgroups of pods are read apart from network namespaces,
+	// neither depends on the other. Results of reads timed out are left
+	// alone, they may still be written in background.
+	reads := make(map[string]func(), len(netnsPids)+len(pods))
+	netns := make(map[string]*netnsStats, len(netnsPids))
+	for id, pid := range netnsPids {
+		pid, pods, stats := pid, netnsPods[id], &netnsStats{}
+		netns[id] = stats
+		reads[id] = func() {
+			*stats = *m.getNetnsStats(pid, pods)
+		}
+	}
+	procs := make(map[*podData]*podProcStats, len(pods))
+	for _, pod := range pods {
+		pod, stats := pod, &podProcStats{}
+		procs[pod] = stats
+		reads[podReadKey(pod)] = func() {
+			*stats = *readPodProcStats(pod)
+		}
+	}
+	timedOut := m.readAll(reads)
+
+	previous := map[info.PodRef]*info.Stats{}
+	last, _ := m.snapshot.Load().([]*info.Stats)
+	for _, s := range last {
+		previous[info.PodRef{Namespace: s.Namespace, PodName: s.PodName}] = s
+	}
+
+	infos := make([]*info.Stats, 0, len(pods))
+	for _, pod := range pods {
+		ref := info.PodRef{Namespace: pod.Namespace, PodName: pod.Name}
+		old := previous[ref]
+		s := &info.Stats{
+			PodName:       pod.Name,
+			Namespace:     pod.Namespace,
+			HostNetwork:   pod.hostNetwork,
+			DeclaredPorts: pod.ports,
+			Containers:    newContainerStats(pod),
+		}
+
+		if timedOut[podReadKey(pod)] {
+			s.Stale = true
+			if old != nil {
+				copyProcStats(s, old)
+			}
+		} else {
+			setProcStats(s, pod, procs[pod])
+		}
+
+		if id, ok := netnsOf[pod]; ok {
+			switch {
+			case timedOut[id]:
+				s.Stale = true
+				if old != nil {
+					copyNetnsStats(s, old)
+				}
+			case netns[id].err != nil:
+				log.Errorf("err get stats of pod %v: %v", pod.Name, netns[id].err)
+			default:
+				setNetnsStats(s, pod, netns[id], m.processSockets)
+			}
+		}
+
+		if s.Stale {
+			m.recordTimeout(ref)
+		}
+		infos = append(infos, s)
+	}
+
+	m.snapshot.Store(infos)
+}
+
+// podReadKey identifies reading processes and cgroups of pod, apart from
+// network namespace IDs, e.g. net:[4026531993].
+func podReadKey(pod *podData) string {
+	return "pod:" + pod.UID
+}
+
+// readAll calls reads keyed by what they read with collectWorkers readers,
+// and returns keys of the ones not done in collectTimeout.
+func (m *Manager) readAll(reads map[string]func()) map[string]bool {
+	workers := m.collectWorkers
+	if workers > len(reads) {
+		workers = len(reads)
+	}
+
+	var lock sync.Mutex
+	timedOut := map[string]bool{}
+	keys := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				if !m.read(key, reads[key]) {
+					lock.Lock()
+					timedOut[key] = true
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+	for key := range reads {
+		keys <- key
+	}
+	close(keys)
+	wg.Wait()
+	return timedOut
+}
+
+// read calls fn and waits for it in collectTimeout, false if it times out.
+// Reading /proc can't be interrupted, so fn goes on in background after
+// timeout and key times out again until it finishes.
+func (m *Manager) read(key string, fn func()) bool {
+	m.readingLock.Lock()
+	if m.reading[key] {
+		m.readingLock.Unlock()
+		return false
+	}
+	m.reading[key] = true
+	m.readingLock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		fn()
+		m.readingLock.Lock()
+		delete(m.reading, key)
+		m.readingLock.Unlock()
+		close(done)
+	}()
+
+	timer := time.NewTimer(m.collectTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		log.Warningf("Reading %v takes longer than %v", key, m.collectTimeout)
+		return false
+	}
+}
+
+// newContainerStats lists containers of pod, nil if pid source doesn't know
+// containers.
+func newContainerStats(pod *podData) []info.ContainerStats {
+	if len(pod.Containers) == 0 {
+		return nil
+	}
+	stats := make([]info.ContainerStats, 0, len(pod.Containers))
+	for _, cont := range pod.Containers {
+		stats = append(stats, info.ContainerStats{Name: cont.Name})
+	}
+	return stats
+}
+
+// setProcStats fills stats of processes and cgroups of pod into s, whose
+// containers are listed by newContainerStats.
+func setProcStats(s *info.Stats, pod *podData, procs *podProcStats) {
+	for i, cont := range pod.Containers {
+		if p, ok := procs.containers[cont]; ok {
+			s.Containers[i].Fds = p.fds
+			s.Containers[i].Processes = p.processes
+			s.Containers[i].Pids = p.pids
+		}
+	}
+	s.Processes = podProcessStats(s.Containers)
+	s.Pids = procs.pids
+	s.Pressure = procs.pressure
+	s.ProcessesCollectedAt = procs.collectedAt
+}
+
+// setNetnsStats fills stats of network namespace of pod into s, whose
+// containers are listed by newContainerStats.
+func setNetnsStats(s *info.Stats, pod *podData, netns *netnsStats, commands bool) {
+	s.Network = netns.network
+	s.Counters = netns.counters
+	s.RemoteEndpoints = netns.remoteEndpoints
+	s.CollectedAt = netns.collectedAt
+	s.Commands = commandStats(pod, netns.network, commands)
+	if netns.network.Owners == nil {
+		return
+	}
+	for i := range s.Containers {
+		cont := &s.Containers[i]
+		cont.Tcp = &network.TcpStat{}
+		// Sockets may be attributed to commands of container.
+		for owner, stat := range netns.network.Owners {
+			if owner.PodUID == pod.UID && owner.Container == cont.Name {
+				cont.Tcp.Add(&stat)
+			}
+		}
+	}
+}
+
+// copyProcStats copies stats of processes and cgroups of old collection
+// into s.
+func copyProcStats(s, old *info.Stats) {
+	for i := range s.Containers {
+		if c := findContainer(old, s.Containers[i].Name); c != nil {
+			s.Containers[i].Fds = c.Fds
+			s.Containers[i].Processes = c.Processes
+			s.Containers[i].Pids = c.Pids
+		}
+	}
+	s.Processes = old.Processes
+	s.Pids = old.Pids
+	s.Pressure = old.Pressure
+	s.ProcessesCollectedAt = old.ProcessesCollectedAt
+}
+
+// copyNetnsStats copies stats of network namespace of old collection into s.
+func copyNetnsStats(s, old *info.Stats) {
+	for i := range s.Containers {
+		if c := findContainer(old, s.Containers[i].Name); c != nil {
+			s.Containers[i].Tcp = c.Tcp
+		}
+	}
+	s.Network = old.Network
+	s.Counters = old.Counters
+	s.RemoteEndpoints = old.RemoteEndpoints
+	s.CollectedAt = old.CollectedAt
+	s.Commands = old.Commands
+}
+
+func findContainer(s *info.Stats, name string) *info.ContainerStats {
+	for i := range s.Containers {
+		if s.Containers[i].Name == name {
+			return &s.Containers[i]
+		}
+	}
+	return nil
+}
+
+// podProcessStats sums processes of containers, nil if none is readable.
+func podProcessStats(containers []info.ContainerStats) *info.ProcessStats {
+	var stats *info.ProcessStats
+	for i := range containers {
+		if containers[i].Processes == nil {
+			continue
+		}
+		if stats == nil {
+			stats = &info.ProcessStats{}
+		}
+		stats.Add(containers[i].Processes)
+	}
+	return stats
+}
+
+// commandStats picks stats of commands of pod from stats of its network
+// namespace, nil unless sockets are attributed to commands.
+func commandStats(pod *podData, netStat *network.Stats, commands bool) []info.CommandStats {
+	if !commands || netStat.Owners == nil {
+		return nil
+	}
+	stats := []info.CommandStats{}
+	for owner, stat := range netStat.Owners {
+		if owner.PodUID != pod.UID {
+			continue
+		}
+		stats = append(stats, info.CommandStats{
+			Container: owner.Container,
+			Command:   owner.Command,
+			Tcp:       stat,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Container != stats[j].Container {
+			return stats[i].Container < stats[j].Container
+		}
+		return stats[i].Command < stats[j].Command
+	})
+	return stats
+}
+
+func (m *Manager) recordTimeout(ref info.PodRef) {
+	m.errorsLock.Lock()
+	m.collectTimeouts[ref]++
+	m.errorsLock.Unlock()
+}
+
+// containerProcStats are stats of processes of a container, they are nil if
+// not readable.
+type containerProcStats struct {
+	fds       *info.FdStats
+	processes *info.ProcessStats
+	pids      *info.PidsCgroupStats
+}
+
+// podProcStats are stats of processes and cgroups of a pod, they are nil if
+// not readable.
+type podProcStats struct {
+	containers  map[*containerData]*containerProcStats
+	pids        *info.PidsCgroupStats
+	pressure    []info.PressureStats
+	collectedAt time.Time
+}
+
+func readPodProcStats(pod *podData) *podProcStats {
+	stats := &podProcStats{
+		containers: make(map[*containerData]*containerProcStats, len(pod.Containers)),
+		pids:       readPidsCgroup(pod.pidsCgroup),
+		pressure:   readPressure(pod.unifiedCgroup),
+	}
+	for _, cont := range pod.Containers {
+		procs := cont.currentProcesses()
+		stats.containers[cont] = &containerProcStats{
+			fds:       readFdStats(procs),
+			processes: readProcessStats(procs),
+			pids:      readPidsCgroup(cont.pidsCgroup),
+		}
+	}
+	stats.collectedAt = time.Now()
+	return stats
+}
+
+// netnsStats are stats of a network namespace, pods sharing it share them.
+type netnsStats struct {
+	network         *network.Stats
+	counters        network.Counters
+	remoteEndpoints map[string]resolver.Endpoint
+	collectedAt     time.Time
+	err             error
+}
+
+func (m *Manager) getNetnsStats(pid int, pods []*podData) *netnsStats {
+	var owners network.SocketOwners
+	if m.containerSockets || m.processSockets {
+		owners = socketOwners(pods, m.processSockets, m.maxCommands)
+	}
+	netStat, err := m.networkStatsProvider.GetStats(hostRootfsPath, pid, owners)
+	if err != nil {
+		return &netnsStats{err: fmt.Errorf("err get network stats: %v", err)}
+	}
+
+	counters, err := m.countersProvider.GetCounters(hostRootfsPath, pid)
+	if err != nil {
+		return &netnsStats{err: fmt.Errorf("err get protocol counters: %v", err)}
+	}
+
+	stats := &netnsStats{
+		network:     netStat,
+		counters:    counters,
+		collectedAt: time.Now(),
+	}
+	if m.resolver != nil {
+		stats.remoteEndpoints = make(map[string]resolver.Endpoint, len(netStat.Remotes)+len(netStat.Outbound))
+		for _, remotes := range [][]network.RemoteStat{netStat.Remotes, netStat.Outbound} {
+			for _, remote := range remotes {
+				if _, ok := stats.remoteEndpoints[remote.IP]; !ok {
+					stats.remoteEndpoints[remote.IP] = m.resolver.Resolve(remote.IP)
+				}
+			}
+		}
+	}
+	return stats
+}
+
+// netnsID identifies network namespace of pid, e.g. net:[4026531993].
+func netnsID(pid int) (string, error) {
+	return os.Readlink(path.Join(hostRootfsPath, "proc", strconv.Itoa(pid), "ns/net"))
+}
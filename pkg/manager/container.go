@@ -3,35 +3,91 @@ package manager
 import (
 	"fmt"
 	"io/ioutil"
-	"os"
 	"path"
 	"strconv"
 	"strings"
 
 	"github.com/caicloud/nirvana/log"
+	"github.com/caitong93/kube-extra-exporter/pkg/info"
 
 	v1 "k8s.io/api/core/v1"
 )
 
 type podData struct {
-	Name       string
-	Namespace  string
-	UID        string
-	qos        v1.PodQOSClass
-	Containers []*containerData
+	Name      string
+	Namespace string
+	UID       string
+	qos       v1.PodQOSClass
+	// hostNetwork pods are in network namespace of host
+	hostNetwork bool
+	Containers  []*containerData
+	// containerIDs are IDs in status of pod when it's discovered
+	containerIDs []string
+	// sandboxPid is pid of pod sandbox, 0 if pid source doesn't know it
+	sandboxPid int
+	// ports declared in spec of pod
+	ports []info.DeclaredPort
+	// pidsCgroup is path of pod in pids hierarchy, empty if unknown
+	pidsCgroup string
+	// unifiedCgroup is path of pod in unified hierarchy, empty if unknown
+	unifiedCgroup string
 }
 
 func newPodData(po *v1.Pod) *podData {
 	return &podData{
-		Name:      po.Name,
-		Namespace: po.Namespace,
-		UID:       string(po.UID),
-		qos:       po.Status.QOSClass,
+		Name:         po.Name,
+		Namespace:    po.Namespace,
+		UID:          string(po.UID),
+		qos:          po.Status.QOSClass,
+		hostNetwork:  po.Spec.HostNetwork,
+		ports:        declaredPorts(po),
+		containerIDs: statusContainerIDs(po),
 	}
 }
 
-func (pd *podData) addContainer(ID string) error {
-	newCont, err := newContainerData(pd.qos, pd.UID, ID)
+func statusContainerIDs(po *v1.Pod) []string {
+	IDs := make([]string, 0, len(po.Status.ContainerStatuses))
+	for _, cont := range po.Status.ContainerStatuses {
+		IDs = append(IDs, cont.ContainerID)
+	}
+	return IDs
+}
+
+// sameContainers checks whether containers of pod are still the ones when
+// pod is discovered, they change as containers restart.
+func (pd *podData) sameContainers(po *v1.Pod) bool {
+	IDs := statusContainerIDs(po)
+	if len(IDs) != len(pd.containerIDs) {
+		return false
+	}
+	for i := range IDs {
+		if IDs[i] != pd.containerIDs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func declaredPorts(po *v1.Pod) []info.DeclaredPort {
+	ports := []info.DeclaredPort{}
+	for _, cont := range po.Spec.Containers {
+		for _, port := range cont.Ports {
+			proto := port.Protocol
+			if proto == "" {
+				proto = v1.ProtocolTCP
+			}
+			ports = append(ports, info.DeclaredPort{
+				Container: cont.Name,
+				Proto:     strings.ToLower(string(proto)),
+				Port:      uint16(port.ContainerPort),
+			})
+		}
+	}
+	return ports
+}
+
+func (pd *podData) addContainer(cgroups *cgroupResolver, name, ID string) error {
+	newCont, err := newContainerData(cgroups, pd.qos, pd.UID, name, ID)
 	if err != nil {
 		return err
 	}
@@ -62,6 +118,9 @@ func (pd *podData) deleteContainer(ID string) {
 }
 
 func (pd *podData) onePid() int {
+	if pd.sandboxPid > 0 {
+		return pd.sandboxPid
+	}
 	for _, cont := range pd.Containers {
 		if len(cont.Pids) > 0 {
 			return cont.Pids[0]
@@ -71,59 +130,87 @@ func (pd *podData) onePid() int {
 }
 
 type containerData struct {
+	// Name of container in spec of pod
+	Name string
 	ID   string
 	Pids []int
+	// pidsCgroup is path of container in pids hierarchy, empty if unknown
+	pidsCgroup string
+	// cgroupPath is path of container in hierarchy Pids are read from, empty
+	// if pid source doesn't know it
+	cgroupPath string
+}
+
+// currentProcesses reads processes of container from its cgroup.procs, so
+// processes started since discovery are found. Processes of Pids are used if
+// cgroup is unknown or not readable.
+func (c *containerData) currentProcesses() []int {
+	if c.cgroupPath != "" {
+		if pids, err := readPids(path.Join(c.cgroupPath, "cgroup.procs")); err == nil {
+			return pids
+		}
+	}
+	return processes(c.Pids)
 }
 
 // Remove cri prefix, e.g. docker://999a54e3e9eb3c1bf58c96788850aa03a47d3e3c009da9ecae8d2edfdba5a328
 func parseContainerID(ID string) string {
+	_, ID = splitContainerID(ID)
+	return ID
+}
+
+// splitContainerID splits container ID into runtime and ID of the runtime,
+// runtime is empty if ID has no cri prefix.
+func splitContainerID(ID string) (string, string) {
 	i := strings.Index(ID, "://")
 	if i < 0 {
-		return ID
+		return "", ID
 	}
-	return ID[i+3:]
+	return ID[:i], ID[i+3:]
 }
 
-func newContainerData(qos v1.PodQOSClass, podUID, containerID string) (*containerData, error) {
-	containerID = parseContainerID(containerID)
-	cgroupPath, err := resolveCgroupPath(qos, podUID, containerID)
+func newContainerData(cgroups *cgroupResolver, qos v1.PodQOSClass, podUID, name, containerID string) (*containerData, error) {
+	runtime, containerID := splitContainerID(containerID)
+	cgroupPath, err := cgroups.containerPath(qos, podUID, runtime, containerID)
 	if err != nil {
-		return nil, fmt.Errorf("err get cgroup path for podID=%v, containerID=%v: %v", podUID, containerID, err)
+		return nil, newDiscoveryError(reasonCgroupNotFound, "err get cgroup path for podID=%v, containerID=%v: %v", podUID, containerID, err)
 	}
-	pids, err := parseCgroupTasks(cgroupPath)
+	pids, err := parseCgroupProcs(cgroups.mode, cgroupPath)
 	if err != nil {
-		return nil, fmt.Errorf("err parse cgroup tasks: %v", err)
+		return nil, newDiscoveryError(reasonPidsUnreadable, "err parse cgroup tasks: %v", err)
 	}
 
 	return &containerData{
-		ID:   containerID,
-		Pids: pids,
+		Name:       name,
+		ID:         containerID,
+		Pids:       pids,
+		pidsCgroup: cgroups.pathIn(cgroups.pidsRoot, cgroupPath),
+		cgroupPath: cgroupPath,
 	}, nil
 }
 
-func resolveCgroupPath(qos v1.PodQOSClass, podUID, contID string) (string, error) {
-	var cPath string
-
-	switch qos {
-	case v1.PodQOSGuaranteed:
-		cPath = path.Join(hostRootfsPath, fmt.Sprintf("/sys/fs/cgroup/cpu/kubepods/pod%s/%s", podUID, contID))
-	case v1.PodQOSBestEffort:
-		cPath = path.Join(hostRootfsPath, fmt.Sprintf("/sys/fs/cgroup/cpu/kubepods/besteffort/pod%s/%s", podUID, contID))
-	case v1.PodQOSBurstable:
-		cPath = path.Join(hostRootfsPath, fmt.Sprintf("/sys/fs/cgroup/cpu/kubepods/burstable/pod%s/%s", podUID, contID))
+// parseCgroupProcs reads pids in cgroup, the unified hierarchy has no tasks
+// file and lists processes in cgroup.procs.
+func parseCgroupProcs(mode cgroupMode, cgroupPath string) ([]int, error) {
+	file := "tasks"
+	if mode == cgroupV2 {
+		file = "cgroup.procs"
+	}
+	pids, err := readPids(path.Join(cgroupPath, file))
+	if err != nil {
+		return nil, err
 	}
 
-	if _, err := os.Stat(cPath); err == nil {
-		return cPath, nil
-	} else {
-		return "", err
+	if len(pids) == 0 {
+		log.Warningf("pid not found under %v, %v is empty", cgroupPath, file)
 	}
 
-	return "", fmt.Errorf("invalid qos %v", qos)
+	return pids, nil
 }
 
-func parseCgroupTasks(cgroupPath string) ([]int, error) {
-	data, err := ioutil.ReadFile(path.Join(cgroupPath, "tasks"))
+// readPids reads pids in file, one per line, e.g. tasks or cgroup.procs.
+func readPids(file string) ([]int, error) {
+	data, err := ioutil.ReadFile(file)
 	if err != nil {
 		return nil, err
 	}
@@ -140,10 +227,5 @@ func parseCgroupTasks(cgroupPath string) ([]int, error) {
 		}
 		pids = append(pids, pid)
 	}
-
-	if len(pids) == 0 {
-		log.Warningf("pid not found under %v, tasks content: %v", cgroupPath, string(data))
-	}
-
 	return pids, nil
 }
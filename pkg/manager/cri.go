@@ -0,0 +1,193 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	v1 "k8s.io/api/core/v1"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// criEndpoints are runtime sockets tried in order if endpoint is not set.
+// dockershim is left out, its sandbox status carries no pid.
+var criEndpoints = []string{
+	"/run/containerd/containerd.sock",
+	"/run/crio/crio.sock",
+}
+
+const criTimeout = 10 * time.Second
+
+// criSource asks container runtime for sandboxes of pods. Sandbox owns the
+// network namespace of pod and lives as long as pod, whatever cgroup layout
+// kubelet uses.
+type criSource struct {
+	endpoint string
+	conn     *grpc.ClientConn
+	client   runtimeapi.RuntimeServiceClient
+	// sandboxes maps pod UID to ID of its ready sandbox.
+	sandboxes map[string]string
+}
+
+// newCRISource connects runtime at endpoint under rootFs, endpoint may have
+// a unix:// prefix.
+func newCRISource(rootFs, endpoint string) (*criSource, error) {
+	endpoint = strings.TrimPrefix(endpoint, "unix://")
+	if endpoint == "" {
+		for _, e := range criEndpoints {
+			if _, err := os.Stat(path.Join(rootFs, e)); err == nil {
+				endpoint = e
+				break
+			}
+		}
+		if endpoint == "" {
+			return nil, fmt.Errorf("no container runtime socket found in %v", criEndpoints)
+		}
+	}
+
+	addr := path.Join(rootFs, endpoint)
+	ctx, cancel := context.WithTimeout(context.Background(), criTimeout)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithInsecure(), grpc.WithBlock(),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", addr)
+		}))
+	if err != nil {
+		return nil, fmt.Errorf("err connect container runtime %v: %v", addr, err)
+	}
+
+	return &criSource{
+		endpoint: endpoint,
+		conn:     conn,
+		client:   runtimeapi.NewRuntimeServiceClient(conn),
+	}, nil
+}
+
+func (s *criSource) close() error {
+	return s.conn.Close()
+}
+
+func (s *criSource) renew() error {
+	ctx, cancel := context.WithTimeout(context.Background(), criTimeout)
+	defer cancel()
+	resp, err := s.client.ListPodSandbox(ctx, &runtimeapi.ListPodSandboxRequest{
+		Filter: &runtimeapi.PodSandboxFilter{
+			State: &runtimeapi.PodSandboxStateValue{State: runtimeapi.PodSandboxState_SANDBOX_READY},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("err list pod sandboxes: %v", err)
+	}
+
+	// A pod may have several sandboxes while kubelet recreates it, the
+	// latest attempt is used.
+	sandboxes := make(map[string]string, len(resp.Items))
+	attempts := make(map[string]uint32, len(resp.Items))
+	for _, sandbox := range resp.Items {
+		if sandbox.Metadata == nil {
+			continue
+		}
+		uid := sandbox.Metadata.Uid
+		if _, ok := sandboxes[uid]; ok && attempts[uid] > sandbox.Metadata.Attempt {
+			continue
+		}
+		sandboxes[uid] = sandbox.Id
+		attempts[uid] = sandbox.Metadata.Attempt
+	}
+	s.sandboxes = sandboxes
+	return nil
+}
+
+// sandboxInfo is the part of verbose sandbox status containerd and CRI-O
+// report in info.
+type sandboxInfo struct {
+	Pid         int `json:"pid"`
+	RuntimeSpec struct {
+		Linux struct {
+			Namespaces []struct {
+				Type string `json:"type"`
+				Path string `json:"path"`
+			} `json:"namespaces"`
+		} `json:"linux"`
+	} `json:"runtimeSpec"`
+}
+
+// netns is path of network namespace of sandbox, empty if sandbox is in host
+// network or runtime doesn't report it.
+func (info *sandboxInfo) netns() string {
+	for _, ns := range info.RuntimeSpec.Linux.Namespaces {
+		if ns.Type == "network" {
+			return ns.Path
+		}
+	}
+	return ""
+}
+
+func (s *criSource) fill(po *v1.Pod, data *podData) error {
+	id, ok := s.sandboxes[string(po.UID)]
+	if !ok {
+		// Pod may be created after sandboxes are listed.
+		if err := s.renew(); err != nil {
+			return err
+		}
+		id, ok = s.sandboxes[string(po.UID)]
+	}
+	if !ok {
+		return newDiscoveryError(reasonSandboxNotFound, "ready sandbox of pod %v/%v not found", po.Namespace, po.Name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), criTimeout)
+	defer cancel()
+	resp, err := s.client.PodSandboxStatus(ctx, &runtimeapi.PodSandboxStatusRequest{
+		PodSandboxId: id,
+		Verbose:      true,
+	})
+	if err != nil {
+		return newDiscoveryError(reasonRuntimeError, "err get status of sandbox %v: %v", id, err)
+	}
+
+	raw, ok := resp.Info["info"]
+	if !ok {
+		return newDiscoveryError(reasonRuntimeError, "runtime reports no info of sandbox %v", id)
+	}
+	var info sandboxInfo
+	if err := json.Unmarshal([]byte(raw), &info); err != nil {
+		return newDiscoveryError(reasonRuntimeError, "err parse info of sandbox %v: %v", id, err)
+	}
+	if info.Pid <= 0 {
+		return newDiscoveryError(reasonRuntimeError, "runtime reports no pid of sandbox %v", id)
+	}
+	// Network stats are read through /proc/<pid>, so the pid is what's kept.
+	// A pid reused after sandbox exits would read another namespace, it is
+	// checked against netns path of sandbox.
+	if netns := info.netns(); netns != "" {
+		if err := checkNetns(info.Pid, netns); err != nil {
+			return newDiscoveryError(reasonRuntimeError, "err check network namespace of sandbox %v: %v", id, err)
+		}
+	}
+	data.sandboxPid = info.Pid
+	return nil
+}
+
+// checkNetns checks pid is in network namespace bound at netns.
+func checkNetns(pid int, netns string) error {
+	want, err := os.Stat(path.Join(hostRootfsPath, netns))
+	if err != nil {
+		return err
+	}
+	got, err := os.Stat(path.Join(hostRootfsPath, "proc", strconv.Itoa(pid), "ns/net"))
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(want, got) {
+		return fmt.Errorf("pid %v is not in %v", pid, netns)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package manager
+
+import (
+	"fmt"
+)
+
+// Reasons of failures discovering pods.
+const (
+	reasonCgroupNotFound  = "cgroup_not_found"
+	reasonPidsUnreadable  = "pids_unreadable"
+	reasonSandboxNotFound = "sandbox_not_found"
+	reasonRuntimeError    = "runtime_error"
+	reasonUnknown         = "unknown"
+)
+
+// discoveryError is a failure discovering a pod, reason labels its metric.
+type discoveryError struct {
+	reason string
+	err    error
+}
+
+func (e *discoveryError) Error() string {
+	return e.err.Error()
+}
+
+func newDiscoveryError(reason string, format string, args ...interface{}) error {
+	return &discoveryError{reason: reason, err: fmt.Errorf(format, args...)}
+}
+
+func discoveryReason(err error) string {
+	if e, ok := err.(*discoveryError); ok {
+		return e.reason
+	}
+	return reasonUnknown
+}
@@ -0,0 +1,261 @@
+package manager
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/caitong93/kube-extra-exporter/pkg/info"
+	"github.com/caitong93/kube-extra-exporter/pkg/network"
+)
+
+// walkFds calls fn with target of every open fd of pid, e.g. socket:[20931].
+// Fds closed while walking are skipped.
+func walkFds(pid int, fn func(target string)) error {
+	dir := path.Join(hostRootfsPath, "proc", strconv.Itoa(pid), "fd")
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		target, err := os.Readlink(path.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		fn(target)
+	}
+	return nil
+}
+
+// socketInode parses inode of fd target of a socket, e.g. socket:[20931].
+func socketInode(target string) (uint64, bool) {
+	if !strings.HasPrefix(target, "socket:[") || !strings.HasSuffix(target, "]") {
+		return 0, false
+	}
+	inode, err := strconv.ParseUint(target[len("socket:["):len(target)-1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return inode, true
+}
+
+// otherCommand labels sockets of commands beyond maxCommands of a container.
+const otherCommand = "other"
+
+// socketOwners maps sockets opened by processes of containers of pods to the
+// containers. If commands is set, sockets are attributed to commands of the
+// processes too, the maxCommands commands of a container with the most
+// sockets are told apart and sockets of the rest are attributed to
+// otherCommand. Threads share fds of their process, so fds are walked once
+// per process. Processes gone or not readable are skipped.
+func socketOwners(pods []*podData, commands bool, maxCommands int) network.SocketOwners {
+	owners := network.SocketOwners{}
+	for _, pod := range pods {
+		for _, cont := range pod.Containers {
+			owner := network.SocketOwner{PodUID: pod.UID, Container: cont.Name}
+			if !commands {
+				for _, pid := range cont.currentProcesses() {
+					walkFds(pid, func(target string) {
+						if inode, ok := socketInode(target); ok {
+							owners[inode] = owner
+						}
+					})
+				}
+				continue
+			}
+
+			sockets := commandSockets(cont.currentProcesses())
+			named := topCommands(sockets, maxCommands)
+			for comm, inodes := range sockets {
+				owner.Command = otherCommand
+				if named[comm] {
+					owner.Command = comm
+				}
+				for _, inode := range inodes {
+					owners[inode] = owner
+				}
+			}
+		}
+	}
+	return owners
+}
+
+// commandSockets groups inodes of sockets opened by processes by their
+// command names, commands without sockets are left out.
+func commandSockets(procs []int) map[string][]uint64 {
+	sockets := map[string][]uint64{}
+	for _, pid := range procs {
+		comm, err := readComm(pid)
+		if err != nil {
+			continue
+		}
+		walkFds(pid, func(target string) {
+			if inode, ok := socketInode(target); ok {
+				sockets[comm] = append(sockets[comm], inode)
+			}
+		})
+	}
+	return sockets
+}
+
+// topCommands picks at most n commands with the most sockets, ties are
+// broken by name so the same commands are picked every collection.
+func topCommands(sockets map[string][]uint64, n int) map[string]bool {
+	comms := make([]string, 0, len(sockets))
+	for comm := range sockets {
+		comms = append(comms, comm)
+	}
+	sort.Slice(comms, func(i, j int) bool {
+		ci, cj := len(sockets[comms[i]]), len(sockets[comms[j]])
+		if ci != cj {
+			return ci > cj
+		}
+		return comms[i] < comms[j]
+	})
+	if len(comms) > n {
+		comms = comms[:n]
+	}
+	named := make(map[string]bool, len(comms))
+	for _, comm := range comms {
+		named[comm] = true
+	}
+	return named
+}
+
+// readComm reads command name of pid, e.g. php-fpm.
+func readComm(pid int) (string, error) {
+	data, err := ioutil.ReadFile(path.Join(hostRootfsPath, "proc", strconv.Itoa(pid), "comm"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// processes maps pids to their processes, pids are threads as well in tasks
+// of cgroup v1. Threads gone are skipped.
+func processes(pids []int) []int {
+	seen := map[int]bool{}
+	procs := []int{}
+	for _, pid := range pids {
+		tgid, err := readTgid(pid)
+		if err != nil || seen[tgid] {
+			continue
+		}
+		seen[tgid] = true
+		procs = append(procs, tgid)
+	}
+	return procs
+}
+
+// readTgid reads id of thread group, i.e. process, of pid from its status.
+func readTgid(pid int) (int, error) {
+	f, err := os.Open(path.Join(hostRootfsPath, "proc", strconv.Itoa(pid), "status"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "Tgid:") {
+			return strconv.Atoi(strings.TrimSpace(line[len("Tgid:"):]))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no Tgid in status of pid %v", pid)
+}
+
+// readFdStats counts open fds of processes procs and finds their limits, nil
+// if no process is readable.
+func readFdStats(procs []int) *info.FdStats {
+	var stats *info.FdStats
+	for _, pid := range procs {
+		open, err := countFds(pid)
+		if err != nil {
+			continue
+		}
+		soft, hard, err := readMaxOpenFiles(pid)
+		if err != nil {
+			continue
+		}
+
+		if stats == nil {
+			stats = &info.FdStats{SoftLimit: math.Inf(1), HardLimit: math.Inf(1)}
+		}
+		stats.Open += open
+		stats.SoftLimit = math.Min(stats.SoftLimit, soft)
+		stats.HardLimit = math.Min(stats.HardLimit, hard)
+		if soft > 0 {
+			stats.Utilisation = math.Max(stats.Utilisation, float64(open)/soft)
+		}
+	}
+	return stats
+}
+
+func countFds(pid int) (uint64, error) {
+	f, err := os.Open(path.Join(hostRootfsPath, "proc", strconv.Itoa(pid), "fd"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(len(names)), nil
+}
+
+// readMaxOpenFiles reads soft and hard limits of open files of pid from its
+// limits, unlimited is +Inf.
+// Format: Max open files            1024                 1048576              files
+func readMaxOpenFiles(pid int) (float64, float64, error) {
+	data, err := ioutil.ReadFile(path.Join(hostRootfsPath, "proc", strconv.Itoa(pid), "limits"))
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "Max open files") {
+			continue
+		}
+		fields := strings.Fields(line[len("Max open files"):])
+		if len(fields) < 2 {
+			return 0, 0, fmt.Errorf("invalid limits line: %v", line)
+		}
+		soft, err := parseLimit(fields[0])
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid limits line %v: %v", line, err)
+		}
+		hard, err := parseLimit(fields[1])
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid limits line %v: %v", line, err)
+		}
+		return soft, hard, nil
+	}
+	return 0, 0, fmt.Errorf("no max open files in limits of pid %v", pid)
+}
+
+func parseLimit(s string) (float64, error) {
+	if s == "unlimited" {
+		return math.Inf(1), nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return float64(v), nil
+}
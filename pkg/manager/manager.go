@@ -3,34 +3,192 @@ package manager
 import (
 	"context"
 	"fmt"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/caicloud/nirvana/log"
 	"github.com/caitong93/kube-extra-exporter/pkg/info"
 	"github.com/caitong93/kube-extra-exporter/pkg/network"
 	"github.com/caitong93/kube-extra-exporter/pkg/pod"
+	"github.com/caitong93/kube-extra-exporter/pkg/resolver"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/flowcontrol"
 )
 
 var (
 	hostRootfsPath = "/rootfs"
+	// validateTimeout is how long running pods are waited for validating cgroups.
+	validateTimeout = 30 * time.Second
+	// resyncPeriod is how often all pods are rediscovered.
+	resyncPeriod = time.Minute
+	// retryPeriod is how often failed pods are checked for retry.
+	retryPeriod = time.Second
 )
 
+const (
+	// DefaultCollectInterval is how often stats are collected if not configured.
+	DefaultCollectInterval = 15 * time.Second
+	// DefaultCollectTimeout is how long a pod is read if not configured.
+	DefaultCollectTimeout = 5 * time.Second
+	// DefaultMaxCommands is how many commands of a container are told apart
+	// if not configured.
+	DefaultMaxCommands = 5
+)
+
+// Options configures a Manager.
+type Options struct {
+	Network network.Options
+	// Resolver maps remote addresses to Kubernetes objects, nil disables it.
+	Resolver resolver.Resolver
+	Cgroup   CgroupOptions
+	// PidSource is PidSourceCgroup or PidSourceCRI, PidSourceCgroup if empty.
+	PidSource string
+	// CRIEndpoint is the runtime socket under host rootfs, e.g.
+	// /run/containerd/containerd.sock, it is detected if empty.
+	CRIEndpoint string
+	// ExcludeHostNetwork skips pods in network namespace of host, whose
+	// stats are of the whole node.
+	ExcludeHostNetwork bool
+	// CollectInterval is how often stats of pods are collected in
+	// background, DefaultCollectInterval if not positive.
+	CollectInterval time.Duration
+	// CollectWorkers is how many pods are read in parallel, number of CPUs
+	// if not positive.
+	CollectWorkers int
+	// CollectTimeout is how long stats of a pod are waited for, pods timed
+	// out serve stats of last collection. DefaultCollectTimeout if not
+	// positive.
+	CollectTimeout time.Duration
+	// ContainerSockets attributes tcp connections to containers by matching
+	// fds of their processes to sockets, PidSourceCgroup only.
+	ContainerSockets bool
+	// ProcessSockets attributes tcp connections to command names of
+	// processes as well as containers, PidSourceCgroup only.
+	ProcessSockets bool
+	// MaxCommands is how many commands of a container connections are
+	// attributed to, the rest are counted as "other". DefaultMaxCommands if
+	// not positive.
+	MaxCommands int
+}
+
+// Sources of pids of pods.
+const (
+	// PidSourceCgroup reads pids of containers from their cgroups.
+	PidSourceCgroup = "cgroup"
+	// PidSourceCRI asks container runtime for pids of pod sandboxes, which
+	// own network namespaces of pods.
+	PidSourceCRI = "cri"
+)
+
+// pidSource finds processes of pods, renew is called before pods are filled
+// in every round of discovery, close releases it once manager stops.
+type pidSource interface {
+	renew() error
+	fill(po *v1.Pod, data *podData) error
+	close() error
+}
+
 type Manager struct {
 	podLister            pod.Lister
 	networkStatsProvider network.StatsProvider
+	countersProvider     network.CountersProvider
+	resolver             resolver.Resolver
+	cgroupOptions        CgroupOptions
+	pidSource            string
+	criEndpoint          string
+	excludeHostNetwork   bool
+	collectInterval      time.Duration
+	collectWorkers       int
+	collectTimeout       time.Duration
+	containerSockets     bool
+	processSockets       bool
+	maxCommands          int
 
 	containersLock sync.Mutex
 	pods           map[string]*podData
+
+	// failed pods are retried with backoff, they are only accessed by the
+	// goroutine discovering pods.
+	failed  map[string]*v1.Pod
+	backoff *flowcontrol.Backoff
+
+	errorsLock      sync.Mutex
+	discoveryErrors map[info.DiscoveryError]uint64
+	collectTimeouts map[info.PodRef]uint64
+
+	// reading are network namespaces whose reading is not finished yet.
+	readingLock sync.Mutex
+	reading     map[string]bool
+
+	// synced is closed once pods are discovered the first time.
+	synced chan struct{}
+
+	// snapshot holds []*info.Stats of the latest collection.
+	snapshot atomic.Value
 }
 
-func New(podLister pod.Lister) (*Manager, error) {
+func New(podLister pod.Lister, opts Options) (*Manager, error) {
+	networkStatsProvider, err := network.NewStatsProvider(opts.Network)
+	if err != nil {
+		return nil, err
+	}
+	switch opts.PidSource {
+	case "", PidSourceCgroup, PidSourceCRI:
+	default:
+		return nil, fmt.Errorf("unknown pid source %q", opts.PidSource)
+	}
+	if (opts.ContainerSockets || opts.ProcessSockets) && opts.PidSource == PidSourceCRI {
+		return nil, fmt.Errorf("attributing sockets to containers requires %s pid source", PidSourceCgroup)
+	}
+	if opts.Cgroup.Driver != "" {
+		if _, err := newCgroupDriver(opts.Cgroup.Driver); err != nil {
+			return nil, err
+		}
+	}
+	collectInterval := opts.CollectInterval
+	if collectInterval <= 0 {
+		collectInterval = DefaultCollectInterval
+	}
+	collectWorkers := opts.CollectWorkers
+	if collectWorkers <= 0 {
+		collectWorkers = runtime.NumCPU()
+	}
+	collectTimeout := opts.CollectTimeout
+	if collectTimeout <= 0 {
+		collectTimeout = DefaultCollectTimeout
+	}
+	maxCommands := opts.MaxCommands
+	if maxCommands <= 0 {
+		maxCommands = DefaultMaxCommands
+	}
+
 	return &Manager{
 		pods:                 make(map[string]*podData),
 		podLister:            podLister,
-		networkStatsProvider: network.NewStatsProvider(),
+		networkStatsProvider: networkStatsProvider,
+		countersProvider:     network.NewCountersProvider(),
+		resolver:             opts.Resolver,
+		cgroupOptions:        opts.Cgroup,
+		pidSource:            opts.PidSource,
+		criEndpoint:          opts.CRIEndpoint,
+		excludeHostNetwork:   opts.ExcludeHostNetwork,
+		collectInterval:      collectInterval,
+		collectWorkers:       collectWorkers,
+		collectTimeout:       collectTimeout,
+		containerSockets:     opts.ContainerSockets,
+		processSockets:       opts.ProcessSockets,
+		maxCommands:          maxCommands,
+		failed:               make(map[string]*v1.Pod),
+		backoff:              flowcontrol.NewBackOff(time.Second, resyncPeriod),
+		discoveryErrors:      make(map[info.DiscoveryError]uint64),
+		collectTimeouts:      make(map[info.PodRef]uint64),
+		reading:              make(map[string]bool),
+		synced:               make(chan struct{}),
 	}, nil
 }
 
@@ -47,78 +205,360 @@ func (m *Manager) findPodByUID(UID string) (*v1.Pod, error) {
 	return nil, fmt.Errorf("pod not found")
 }
 
-func (m *Manager) Run(ctx context.Context) error {
-	renewPods := func() error {
-		pods, err := m.podLister.List()
+func (m *Manager) newPidSource(ctx context.Context) (pidSource, error) {
+	if m.pidSource == PidSourceCRI {
+		source, err := newCRISource(hostRootfsPath, m.criEndpoint)
 		if err != nil {
-			log.Error("Err list pods:", err)
+			return nil, err
 		}
-		newPods := make(map[string]*podData)
-		for _, po := range pods {
-			if po.Status.Phase != v1.PodRunning {
-				continue
-			}
+		log.Infof("Found container runtime at %v", source.endpoint)
+		return source, nil
+	}
 
-			UID := string(po.UID)
-			data := newPodData(po)
-			for _, cont := range po.Status.ContainerStatuses {
-				if err := data.addContainer(cont.ContainerID); err != nil {
-					return err
-				}
-			}
+	cgroups, err := newCgroupResolver(hostRootfsPath, m.cgroupOptions)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Found cgroup %v hierarchy %v with %v driver", cgroups.mode, cgroups.root, cgroups.driverName)
+	if err := m.validateCgroups(ctx, cgroups); err != nil {
+		return nil, err
+	}
+	return &cgroupSource{cgroups: cgroups}, nil
+}
+
+// podEvent is a pod added, updated or deleted.
+type podEvent struct {
+	pod     *v1.Pod
+	deleted bool
+}
 
-			newPods[UID] = data
+// Run discovers pods and keeps them updated on pod events until ctx is done,
+// all pods are rediscovered every resyncPeriod in case something is missed.
+// Stats of discovered pods are collected every collectInterval meanwhile.
+func (m *Manager) Run(ctx context.Context) error {
+	source, err := m.newPidSource(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := source.close(); err != nil {
+			log.Errorf("Err close pid source: %v", err)
 		}
+	}()
 
-		// log.Infof("refresh pods %v", pretty.Sprint(newPods))
-		m.containersLock.Lock()
-		m.pods = newPods
-		m.containersLock.Unlock()
-		return nil
+	events := make(chan podEvent, 1024)
+	send := func(obj interface{}, deleted bool) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		po, ok := obj.(*v1.Pod)
+		if !ok {
+			return
+		}
+		select {
+		case events <- podEvent{pod: po, deleted: deleted}:
+		case <-ctx.Done():
+		}
 	}
+	m.podLister.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { send(obj, false) },
+		UpdateFunc: func(old, obj interface{}) { send(obj, false) },
+		DeleteFunc: func(obj interface{}) { send(obj, true) },
+	})
 
-	if err := renewPods(); err != nil {
+	if err := m.resyncPods(source); err != nil {
 		log.Errorf("Err refresh pod infos: %v", err)
 	}
+	close(m.synced)
 
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	wg.Add(1)
 	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-time.After(5 * time.Second):
-				if err := renewPods(); err != nil {
-					log.Errorf("Err refresh pod infos: %v", err)
-				}
+		defer wg.Done()
+		m.runCollector(ctx)
+	}()
+
+	resync := time.NewTicker(resyncPeriod)
+	defer resync.Stop()
+	retry := time.NewTicker(retryPeriod)
+	defer retry.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Infoln("Manager stopped")
+			return nil
+		case e := <-events:
+			m.updatePod(source, e)
+		case <-retry.C:
+			m.retryFailedPods(source)
+		case <-resync.C:
+			if err := m.resyncPods(source); err != nil {
+				log.Errorf("Err refresh pod infos: %v", err)
 			}
+			m.backoff.GC()
 		}
-	}()
+	}
+}
 
-	return nil
+// Synced is closed once pods are discovered the first time after Run.
+func (m *Manager) Synced() <-chan struct{} {
+	return m.synced
 }
 
-func (m *Manager) ListStats() ([]*info.Stats, error) {
+// discoverPod finds processes of pod.
+func (m *Manager) discoverPod(source pidSource, po *v1.Pod) (*podData, error) {
+	data := newPodData(po)
+	if err := source.fill(po, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// resyncPods rediscovers all running pods. Pods failing keep what was
+// discovered last time and are retried with backoff.
+func (m *Manager) resyncPods(source pidSource) error {
+	pods, err := m.podLister.List()
+	if err != nil {
+		log.Error("Err list pods:", err)
+	}
+	if err := source.renew(); err != nil {
+		return err
+	}
+
 	m.containersLock.Lock()
-	defer m.containersLock.Unlock()
+	oldPods := m.pods
+	m.containersLock.Unlock()
 
-	infos := []*info.Stats{}
-	for _, pod := range m.pods {
-		stat := &info.Stats{
-			PodName:   pod.Name,
-			Namespace: pod.Namespace,
+	now := time.Now()
+	newPods := make(map[string]*podData)
+	running := make(map[string]*v1.Pod)
+	for _, po := range pods {
+		if po.Status.Phase != v1.PodRunning {
+			continue
+		}
+		UID := string(po.UID)
+		running[UID] = po
+		if _, failed := m.failed[UID]; failed && m.backoff.IsInBackOffSinceUpdate(UID, now) {
+			m.failed[UID] = po
+			if old, ok := oldPods[UID]; ok {
+				newPods[UID] = old
+			}
+			continue
 		}
 
-		// Fill network stats
-		netStat, err := m.networkStatsProvider.GetStats(hostRootfsPath, pod.onePid())
+		data, err := m.discoverPod(source, po)
 		if err != nil {
-			log.Errorf("err get network stats for pod %v: %v", pod.Name, err)
+			m.recordFailure(po, err)
+			if old, ok := oldPods[UID]; ok {
+				newPods[UID] = old
+			}
 			continue
-			// return nil, err
 		}
-		stat.Network = netStat
+		m.recordSuccess(po)
+		newPods[UID] = data
+	}
+
+	m.forgetPodsExcept(running)
+
+	m.containersLock.Lock()
+	m.pods = newPods
+	m.containersLock.Unlock()
+	return nil
+}
+
+// updatePod discovers pod of event again only if its containers changed or
+// it failed last time.
+func (m *Manager) updatePod(source pidSource, e podEvent) {
+	UID := string(e.pod.UID)
+	if e.deleted || e.pod.Status.Phase != v1.PodRunning {
+		m.containersLock.Lock()
+		delete(m.pods, UID)
+		m.containersLock.Unlock()
+		m.forgetPod(e.pod)
+		return
+	}
+
+	m.containersLock.Lock()
+	old, ok := m.pods[UID]
+	m.containersLock.Unlock()
+	_, failed := m.failed[UID]
+	if ok && !failed && old.sameContainers(e.pod) {
+		return
+	}
+	if failed && m.backoff.IsInBackOffSinceUpdate(UID, time.Now()) {
+		m.failed[UID] = e.pod
+		return
+	}
+
+	data, err := m.discoverPod(source, e.pod)
+	if err != nil {
+		m.recordFailure(e.pod, err)
+		return
+	}
+	m.recordSuccess(e.pod)
+	m.containersLock.Lock()
+	m.pods[UID] = data
+	m.containersLock.Unlock()
+}
+
+// retryFailedPods discovers failed pods whose backoff expired.
+func (m *Manager) retryFailedPods(source pidSource) {
+	now := time.Now()
+	for UID, po := range m.failed {
+		if !m.backoff.IsInBackOffSinceUpdate(UID, now) {
+			m.updatePod(source, podEvent{pod: po})
+		}
+	}
+}
+
+func (m *Manager) recordFailure(po *v1.Pod, err error) {
+	UID := string(po.UID)
+	m.failed[UID] = po
+	m.backoff.Next(UID, time.Now())
+	log.Warningf("Err discover pod %v/%v, retry in %v: %v", po.Namespace, po.Name, m.backoff.Get(UID), err)
+
+	m.errorsLock.Lock()
+	m.discoveryErrors[info.DiscoveryError{
+		Namespace: po.Namespace,
+		PodName:   po.Name,
+		Reason:    discoveryReason(err),
+	}]++
+	m.errorsLock.Unlock()
+}
+
+func (m *Manager) recordSuccess(po *v1.Pod) {
+	UID := string(po.UID)
+	if _, ok := m.failed[UID]; ok {
+		delete(m.failed, UID)
+		m.backoff.Reset(UID)
+	}
+}
+
+// forgetPod drops failures of pod gone.
+func (m *Manager) forgetPod(po *v1.Pod) {
+	UID := string(po.UID)
+	delete(m.failed, UID)
+	m.backoff.DeleteEntry(UID)
+
+	m.errorsLock.Lock()
+	for key := range m.discoveryErrors {
+		if key.Namespace == po.Namespace && key.PodName == po.Name {
+			delete(m.discoveryErrors, key)
+		}
+	}
+	delete(m.collectTimeouts, info.PodRef{Namespace: po.Namespace, PodName: po.Name})
+	m.errorsLock.Unlock()
+}
+
+// forgetPodsExcept drops failures of pods not running, in case their delete
+// events are missed.
+func (m *Manager) forgetPodsExcept(running map[string]*v1.Pod) {
+	for UID := range m.failed {
+		if _, ok := running[UID]; !ok {
+			delete(m.failed, UID)
+			m.backoff.DeleteEntry(UID)
+		}
+	}
+
+	names := make(map[[2]string]bool, len(running))
+	for _, po := range running {
+		names[[2]string{po.Namespace, po.Name}] = true
+	}
+	m.errorsLock.Lock()
+	for key := range m.discoveryErrors {
+		if !names[[2]string{key.Namespace, key.PodName}] {
+			delete(m.discoveryErrors, key)
+		}
+	}
+	for key := range m.collectTimeouts {
+		if !names[[2]string{key.Namespace, key.PodName}] {
+			delete(m.collectTimeouts, key)
+		}
+	}
+	m.errorsLock.Unlock()
+}
 
-		infos = append(infos, stat)
+// DiscoveryErrors returns counts of failures discovering pods on the node.
+func (m *Manager) DiscoveryErrors() map[info.DiscoveryError]uint64 {
+	m.errorsLock.Lock()
+	defer m.errorsLock.Unlock()
+
+	counts := make(map[info.DiscoveryError]uint64, len(m.discoveryErrors))
+	for key, count := range m.discoveryErrors {
+		counts[key] = count
 	}
+	return counts
+}
 
+// CollectTimeouts returns counts of pods on the node not read in time.
+func (m *Manager) CollectTimeouts() map[info.PodRef]uint64 {
+	m.errorsLock.Lock()
+	defer m.errorsLock.Unlock()
+
+	counts := make(map[info.PodRef]uint64, len(m.collectTimeouts))
+	for key, count := range m.collectTimeouts {
+		counts[key] = count
+	}
+	return counts
+}
+
+// validateCgroups fails if containers of running pods are not found in
+// cgroups, which means cgroup options don't match kubelet. Pods are waited
+// for a while since lister may not be synced yet.
+func (m *Manager) validateCgroups(ctx context.Context, cgroups *cgroupResolver) error {
+	var found bool
+	var lastErr error
+	pollCtx, cancel := context.WithTimeout(ctx, validateTimeout)
+	defer cancel()
+	err := wait.PollImmediateUntil(time.Second, func() (bool, error) {
+		pods, err := m.podLister.List()
+		if err != nil {
+			return false, nil
+		}
+		for _, po := range pods {
+			if po.Status.Phase != v1.PodRunning {
+				continue
+			}
+			for _, cont := range po.Status.ContainerStatuses {
+				if cont.ContainerID == "" {
+					continue
+				}
+				runtime, ID := splitContainerID(cont.ContainerID)
+				if _, err := cgroups.containerPath(po.Status.QOSClass, string(po.UID), runtime, ID); err != nil {
+					lastErr = err
+					continue
+				}
+				found = true
+				return true, nil
+			}
+		}
+		return lastErr != nil, nil
+	}, pollCtx.Done())
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err == wait.ErrWaitTimeout {
+		log.Warningf("No running pod is found in %v, cgroups are not validated", validateTimeout)
+		return nil
+	}
+	if !found {
+		return fmt.Errorf("no container of running pods is found in cgroups, check cgroup options: %v", lastErr)
+	}
+	return nil
+}
+
+// TcpInfoEnabled tells whether distributions of tcp_info are collected.
+func (m *Manager) TcpInfoEnabled() bool {
+	return m.networkStatsProvider.TcpInfoEnabled()
+}
+
+// ListStats returns stats of the latest collection without reading anything,
+// they are shared by callers and must not be modified.
+func (m *Manager) ListStats() ([]*info.Stats, error) {
+	infos, _ := m.snapshot.Load().([]*info.Stats)
+	if infos == nil {
+		return []*info.Stats{}, nil
+	}
 	return infos, nil
 }
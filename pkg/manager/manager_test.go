@@ -5,30 +5,79 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"net"
 	"os"
 	"path"
 	"reflect"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/caitong93/kube-extra-exporter/pkg/info"
+	"github.com/caitong93/kube-extra-exporter/pkg/network"
+	"google.golang.org/grpc"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type mockPodLister struct {
-	pods []*v1.Pod
+	pods     []*v1.Pod
+	handlers []cache.ResourceEventHandler
 }
 
 func (p *mockPodLister) List() ([]*v1.Pod, error) {
 	return p.pods, nil
 }
 
+func (p *mockPodLister) AddEventHandler(handler cache.ResourceEventHandler) {
+	p.handlers = append(p.handlers, handler)
+}
+
+// startManager runs mgr until pods are synced, stop cancels it and fails the
+// test if Run doesn't return cleanly.
+func startManager(t *testing.T, mgr *Manager) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- mgr.Run(ctx)
+	}()
+	select {
+	case <-mgr.Synced():
+	case err := <-done:
+		cancel()
+		t.Fatalf("err run manager: %v", err)
+	case <-time.After(10 * time.Second):
+		cancel()
+		t.Fatal("pods are not synced in time")
+	}
+
+	return func() {
+		cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("expect manager stopped cleanly, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("manager is not stopped in time")
+		}
+	}
+}
+
 type testData struct {
-	pods   []*v1.Pod
-	pids   map[string][]int
-	expect map[string]*podData
+	pods []*v1.Pod
+	// unified mounts cgroup v2 hierarchy in fake rootfs
+	unified bool
+	cgroup  CgroupOptions
+	pids    map[string][]int
+	expect  map[string]*podData
 }
 
 func TestManager(t *testing.T) {
@@ -80,10 +129,158 @@ func TestManager(t *testing.T) {
 				},
 			},
 		},
+		{
+			pods: []*v1.Pod{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "bar",
+						UID:  types.UID("5b0c3e43-8d4a-4c47-9b7c-2f1e1a0d6c11"),
+					},
+					Status: v1.PodStatus{
+						QOSClass: v1.PodQOSBurstable,
+						Phase:    v1.PodRunning,
+						ContainerStatuses: []v1.ContainerStatus{
+							{
+								ContainerID: "containerd://0f8a6d1bd4b3f61e0f0c86f1a2b0c3a1a6a5e0f7c1d2e3f4a5b6c7d8e9f0a1b2",
+							},
+						},
+					},
+				},
+			},
+			unified: true,
+			pids: map[string][]int{
+				"/sys/fs/cgroup/kubepods/burstable/pod5b0c3e43-8d4a-4c47-9b7c-2f1e1a0d6c11/0f8a6d1bd4b3f61e0f0c86f1a2b0c3a1a6a5e0f7c1d2e3f4a5b6c7d8e9f0a1b2": []int{4, 5},
+			},
+			expect: map[string]*podData{
+				"5b0c3e43-8d4a-4c47-9b7c-2f1e1a0d6c11": &podData{
+					Name: "bar",
+					UID:  "5b0c3e43-8d4a-4c47-9b7c-2f1e1a0d6c11",
+					Containers: []*containerData{
+						{
+							ID:   "0f8a6d1bd4b3f61e0f0c86f1a2b0c3a1a6a5e0f7c1d2e3f4a5b6c7d8e9f0a1b2",
+							Pids: []int{4, 5},
+						},
+					},
+				},
+			},
+		},
+		{
+			pods: []*v1.Pod{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "baz",
+						UID:  types.UID("8e1f3a52-0c6b-4d1e-a7f9-3b2c5d4e6f70"),
+					},
+					Status: v1.PodStatus{
+						QOSClass: v1.PodQOSBestEffort,
+						Phase:    v1.PodRunning,
+						ContainerStatuses: []v1.ContainerStatus{
+							{
+								ContainerID: "cri-o://6c3f9e2a1b0d4c5e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e",
+							},
+							{
+								ContainerID: "docker://a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f90",
+							},
+						},
+					},
+				},
+			},
+			unified: true,
+			pids: map[string][]int{
+				"/sys/fs/cgroup/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod8e1f3a52_0c6b_4d1e_a7f9_3b2c5d4e6f70.slice/crio-6c3f9e2a1b0d4c5e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e.scope":   []int{6},
+				"/sys/fs/cgroup/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod8e1f3a52_0c6b_4d1e_a7f9_3b2c5d4e6f70.slice/docker-a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f90.scope": []int{7},
+			},
+			expect: map[string]*podData{
+				"8e1f3a52-0c6b-4d1e-a7f9-3b2c5d4e6f70": &podData{
+					Name: "baz",
+					UID:  "8e1f3a52-0c6b-4d1e-a7f9-3b2c5d4e6f70",
+					Containers: []*containerData{
+						{
+							ID:   "6c3f9e2a1b0d4c5e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e",
+							Pids: []int{6},
+						},
+						{
+							ID:   "a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f90",
+							Pids: []int{7},
+						},
+					},
+				},
+			},
+		},
+		{
+			pods: []*v1.Pod{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "qux",
+						UID:  types.UID("0d9e4b7a-2f3c-4a1b-9c8d-7e6f5a4b3c2d"),
+					},
+					Status: v1.PodStatus{
+						QOSClass: v1.PodQOSBurstable,
+						Phase:    v1.PodRunning,
+						ContainerStatuses: []v1.ContainerStatus{
+							{
+								ContainerID: "docker://3e2d1c0b9a8f7e6d5c4b3a291807f6e5d4c3b2a19080f7e6d5c4b3a291807f6e",
+							},
+						},
+					},
+				},
+			},
+			cgroup: CgroupOptions{Root: "/k8s", DisablePerQOS: true},
+			pids: map[string][]int{
+				"/sys/fs/cgroup/cpu/k8s/3e2d1c0b9a8f7e6d5c4b3a291807f6e5d4c3b2a19080f7e6d5c4b3a291807f6e": []int{8},
+			},
+			expect: map[string]*podData{
+				"0d9e4b7a-2f3c-4a1b-9c8d-7e6f5a4b3c2d": &podData{
+					Name: "qux",
+					UID:  "0d9e4b7a-2f3c-4a1b-9c8d-7e6f5a4b3c2d",
+					Containers: []*containerData{
+						{
+							ID:   "3e2d1c0b9a8f7e6d5c4b3a291807f6e5d4c3b2a19080f7e6d5c4b3a291807f6e",
+							Pids: []int{8},
+						},
+					},
+				},
+			},
+		},
+		{
+			pods: []*v1.Pod{
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "quux",
+						UID:  types.UID("4f5e6d7c-8b9a-4c0d-b1e2-f3a4b5c6d7e8"),
+					},
+					Status: v1.PodStatus{
+						QOSClass: v1.PodQOSGuaranteed,
+						Phase:    v1.PodRunning,
+						ContainerStatuses: []v1.ContainerStatus{
+							{
+								ContainerID: "containerd://9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b",
+							},
+						},
+					},
+				},
+			},
+			cgroup: CgroupOptions{Root: "/k8s.slice", Controller: "pids"},
+			pids: map[string][]int{
+				"/sys/fs/cgroup/pids/k8s.slice/k8s-kubepods.slice/k8s-kubepods-pod4f5e6d7c_8b9a_4c0d_b1e2_f3a4b5c6d7e8.slice/cri-containerd-9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b.scope": []int{9},
+			},
+			expect: map[string]*podData{
+				"4f5e6d7c-8b9a-4c0d-b1e2-f3a4b5c6d7e8": &podData{
+					Name: "quux",
+					UID:  "4f5e6d7c-8b9a-4c0d-b1e2-f3a4b5c6d7e8",
+					Containers: []*containerData{
+						{
+							ID:   "9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4b3c2d1e0f9a8b",
+							Pids: []int{9},
+						},
+					},
+				},
+			},
+		},
 	}
 
 	for _, cas := range cases {
-		mgr, err := New(&mockPodLister{cas.pods})
+		mgr, err := New(&mockPodLister{pods: cas.pods}, Options{Cgroup: cas.cgroup})
 		if err != nil {
 			t.Error(err)
 		}
@@ -98,6 +295,14 @@ func TestManager(t *testing.T) {
 			defer os.RemoveAll(tmpDir)
 
 			hostRootfsPath = tmpDir
+			pidsFile := "tasks"
+			if cas.unified {
+				pidsFile = "cgroup.procs"
+				if err := os.MkdirAll(path.Join(hostRootfsPath, "/sys/fs/cgroup"), 0777); err != nil {
+					t.Fatal(err)
+				}
+				ioutil.WriteFile(path.Join(hostRootfsPath, "/sys/fs/cgroup/cgroup.controllers"), []byte("cpu io memory pids\n"), 0777)
+			}
 			for subPath, pids := range cas.pids {
 				fullPath := path.Join(hostRootfsPath, subPath)
 				if err := os.MkdirAll(fullPath, 0777); err != nil {
@@ -107,16 +312,12 @@ func TestManager(t *testing.T) {
 				for _, pid := range pids {
 					buf.WriteString(fmt.Sprintf("%v\n", pid))
 				}
-				ioutil.WriteFile(path.Join(fullPath, "tasks"), buf.Bytes(), 0777)
+				ioutil.WriteFile(path.Join(fullPath, pidsFile), buf.Bytes(), 0777)
 			}
 
-			ctx, cancel := context.WithCancel(context.Background())
-			defer cancel()
-			if err := mgr.Run(ctx); err != nil {
-				t.Error(err)
-			}
+			stop := startManager(t, mgr)
+			defer stop()
 
-			time.Sleep(1 * time.Second)
 			if len(cas.expect) != len(mgr.pods) {
 				t.Errorf("Result length not equal, expect\n%#v,\ngot\n%#v\n", cas.expect, mgr.pods)
 			}
@@ -129,14 +330,912 @@ func TestManager(t *testing.T) {
 				if po.Name != p.Name {
 					t.Error("Pod name")
 				}
-				if !reflect.DeepEqual(po.Containers, p.Containers) {
-					t.Errorf("Containers, expect\n%#v,\ngot\n%#v\n", po.Containers, p.Containers)
+				got := make([]*containerData, 0, len(p.Containers))
+				for _, cont := range p.Containers {
+					c := *cont
+					// pids hierarchy exists in fake rootfs if it's searched.
+					if _, err := os.Stat(c.pidsCgroup); c.pidsCgroup != "" && err != nil {
+						t.Errorf("unexpected pids cgroup of container %v: %v", c.ID, err)
+					}
+					c.pidsCgroup = ""
+					if _, err := os.Stat(c.cgroupPath); err != nil {
+						t.Errorf("unexpected cgroup of container %v: %v", c.ID, err)
+					}
+					c.cgroupPath = ""
+					got = append(got, &c)
+				}
+				if !reflect.DeepEqual(po.Containers, got) {
+					t.Errorf("Containers, expect\n%#v,\ngot\n%#v\n", po.Containers, got)
 				}
 			}
 		}()
 	}
 }
 
+func TestDetectCgroupDriver(t *testing.T) {
+	cases := []struct {
+		cgroup CgroupOptions
+		// dir is created in cpu hierarchy.
+		dir    string
+		expect string
+	}{
+		{cgroup: CgroupOptions{}, dir: "kubepods.slice", expect: CgroupDriverSystemd},
+		{cgroup: CgroupOptions{}, dir: "kubepods", expect: CgroupDriverCgroupfs},
+		{cgroup: CgroupOptions{Root: "/k8s.slice", DisablePerQOS: true}, dir: "k8s.slice", expect: CgroupDriverSystemd},
+		{cgroup: CgroupOptions{Root: "/k8s", DisablePerQOS: true}, dir: "k8s", expect: CgroupDriverCgroupfs},
+		// Containers of cgroupfs right in hierarchy root tell nothing.
+		{cgroup: CgroupOptions{Root: "/", DisablePerQOS: true}, dir: "3e2d1c0b9a8f"},
+		{cgroup: CgroupOptions{Root: "/", DisablePerQOS: true, Driver: CgroupDriverCgroupfs}, dir: "3e2d1c0b9a8f", expect: CgroupDriverCgroupfs},
+	}
+	for _, cas := range cases {
+		func() {
+			tmpDir, err := ioutil.TempDir("", "kube-extra-exporter")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmpDir)
+			if err := os.MkdirAll(path.Join(tmpDir, "sys/fs/cgroup/cpu", cas.dir), 0777); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := newCgroupResolver(tmpDir, cas.cgroup)
+			if cas.expect == "" {
+				if err == nil {
+					t.Errorf("%+v: expect error, got driver %v", cas.cgroup, r.driverName)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("%+v: %v", cas.cgroup, err)
+				return
+			}
+			if r.driverName != cas.expect {
+				t.Errorf("%+v: expect driver %v, got %v", cas.cgroup, cas.expect, r.driverName)
+			}
+		}()
+	}
+}
+
+func TestValidateCgroups(t *testing.T) {
+	saved := hostRootfsPath
+	defer func() {
+		hostRootfsPath = saved
+	}()
+
+	tmpDir, err := ioutil.TempDir("", "kube-extra-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	hostRootfsPath = tmpDir
+	if err := os.MkdirAll(path.Join(tmpDir, "/sys/fs/cgroup/cpu/kubepods"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	pods := []*v1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "foo",
+				UID:  types.UID("1952d77-996a-11e9-81b0-0242ac110002"),
+			},
+			Status: v1.PodStatus{
+				QOSClass: v1.PodQOSGuaranteed,
+				Phase:    v1.PodRunning,
+				ContainerStatuses: []v1.ContainerStatus{
+					{
+						ContainerID: "docker://2726ab85f748125d79e5d64544a632f29f864b79e5905ac8f3398c42ba6a9b3e",
+					},
+				},
+			},
+		},
+	}
+	mgr, err := New(&mockPodLister{pods: pods}, Options{Cgroup: CgroupOptions{Root: "/k8s"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := mgr.Run(ctx); err == nil {
+		t.Error("expect error for pods not found in cgroups")
+	}
+}
+
+func TestPodEvents(t *testing.T) {
+	saved := hostRootfsPath
+	defer func() {
+		hostRootfsPath = saved
+	}()
+
+	tmpDir, err := ioutil.TempDir("", "kube-extra-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	hostRootfsPath = tmpDir
+
+	newPod := func(name, UID, containerID string) *v1.Pod {
+		cgroup := path.Join(tmpDir, "/sys/fs/cgroup/cpu/kubepods/pod"+UID, containerID)
+		if err := os.MkdirAll(cgroup, 0777); err != nil {
+			t.Fatal(err)
+		}
+		ioutil.WriteFile(path.Join(cgroup, "tasks"), []byte("1\n"), 0777)
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, UID: types.UID(UID)},
+			Status: v1.PodStatus{
+				QOSClass:          v1.PodQOSGuaranteed,
+				Phase:             v1.PodRunning,
+				ContainerStatuses: []v1.ContainerStatus{{ContainerID: "docker://" + containerID}},
+			},
+		}
+	}
+	foo := newPod("foo", "1952d77-996a-11e9-81b0-0242ac110002", "2726ab85f748125d79e5d64544a632f29f864b79e5905ac8f3398c42ba6a9b3e")
+	bar := newPod("bar", "5b0c3e43-8d4a-4c47-9b7c-2f1e1a0d6c11", "0f8a6d1bd4b3f61e0f0c86f1a2b0c3a1a6a5e0f7c1d2e3f4a5b6c7d8e9f0a1b2")
+
+	lister := &mockPodLister{pods: []*v1.Pod{foo}}
+	mgr, err := New(lister, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := startManager(t, mgr)
+	defer stop()
+	if len(lister.handlers) != 1 {
+		t.Fatalf("expect an event handler, got %v", len(lister.handlers))
+	}
+
+	lister.handlers[0].OnAdd(bar)
+	lister.handlers[0].OnDelete(cache.DeletedFinalStateUnknown{Key: "foo", Obj: foo})
+
+	err = wait.PollImmediate(10*time.Millisecond, time.Second, func() (bool, error) {
+		mgr.containersLock.Lock()
+		defer mgr.containersLock.Unlock()
+		_, hasFoo := mgr.pods[string(foo.UID)]
+		_, hasBar := mgr.pods[string(bar.UID)]
+		return !hasFoo && hasBar, nil
+	})
+	if err != nil {
+		t.Errorf("expect foo deleted and bar added, got %#v", mgr.pods)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	saved := hostRootfsPath
+	defer func() {
+		hostRootfsPath = saved
+	}()
+
+	tmpDir, err := ioutil.TempDir("", "kube-extra-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	hostRootfsPath = tmpDir
+	if err := os.MkdirAll(path.Join(tmpDir, "/sys/fs/cgroup/cpu/kubepods"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	// No pod is running, Run is waiting for one to validate cgroups.
+	mgr, err := New(&mockPodLister{}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := mgr.Run(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expect deadline exceeded validating cgroups, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > validateTimeout/2 {
+		t.Errorf("expect Run returned on cancel, took %v", elapsed)
+	}
+
+	// Pods are synced, Run blocks until cancelled and event handler doesn't
+	// block once it returns.
+	lister := &mockPodLister{}
+	mgr, err = New(lister, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedTimeout := validateTimeout
+	validateTimeout = 10 * time.Millisecond
+	defer func() {
+		validateTimeout = savedTimeout
+	}()
+	stop := startManager(t, mgr)
+	stop()
+	for i := 0; i < 2048; i++ {
+		lister.handlers[0].OnAdd(&v1.Pod{})
+	}
+}
+
+func TestDiscoveryFailures(t *testing.T) {
+	saved := hostRootfsPath
+	defer func() {
+		hostRootfsPath = saved
+	}()
+
+	tmpDir, err := ioutil.TempDir("", "kube-extra-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	hostRootfsPath = tmpDir
+
+	newPod := func(name, UID, containerID string) (*v1.Pod, string) {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(UID)},
+			Status: v1.PodStatus{
+				QOSClass:          v1.PodQOSGuaranteed,
+				Phase:             v1.PodRunning,
+				ContainerStatuses: []v1.ContainerStatus{{ContainerID: "docker://" + containerID}},
+			},
+		}, path.Join(tmpDir, "/sys/fs/cgroup/cpu/kubepods/pod"+UID, containerID)
+	}
+	createCgroup := func(cgroup string) {
+		if err := os.MkdirAll(cgroup, 0777); err != nil {
+			t.Fatal(err)
+		}
+		ioutil.WriteFile(path.Join(cgroup, "tasks"), []byte("1\n"), 0777)
+	}
+	foo, fooCgroup := newPod("foo", "1952d77-996a-11e9-81b0-0242ac110002", "2726ab85f748125d79e5d64544a632f29f864b79e5905ac8f3398c42ba6a9b3e")
+	bar, barCgroup := newPod("bar", "5b0c3e43-8d4a-4c47-9b7c-2f1e1a0d6c11", "0f8a6d1bd4b3f61e0f0c86f1a2b0c3a1a6a5e0f7c1d2e3f4a5b6c7d8e9f0a1b2")
+	createCgroup(fooCgroup)
+
+	mgr, err := New(&mockPodLister{pods: []*v1.Pod{foo, bar}}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := mgr.newPidSource(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// bar fails without stopping foo from being discovered.
+	if err := mgr.resyncPods(source); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mgr.pods[string(foo.UID)]; !ok || len(mgr.pods) != 1 {
+		t.Errorf("expect only foo discovered, got %#v", mgr.pods)
+	}
+	barErr := info.DiscoveryError{Namespace: "default", PodName: "bar", Reason: reasonCgroupNotFound}
+	if errs := mgr.DiscoveryErrors(); len(errs) != 1 || errs[barErr] != 1 {
+		t.Errorf("expect an error of bar, got %v", errs)
+	}
+
+	// bar is in backoff and not tried again.
+	createCgroup(barCgroup)
+	if err := mgr.resyncPods(source); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mgr.pods[string(bar.UID)]; ok {
+		t.Errorf("expect bar in backoff, got %#v", mgr.pods)
+	}
+
+	// foo keeps what was discovered last time.
+	os.RemoveAll(fooCgroup)
+	mgr.backoff.Reset(string(bar.UID))
+	if err := mgr.resyncPods(source); err != nil {
+		t.Fatal(err)
+	}
+	if len(mgr.pods) != 2 {
+		t.Errorf("expect foo and bar, got %#v", mgr.pods)
+	}
+	fooErr := info.DiscoveryError{Namespace: "default", PodName: "foo", Reason: reasonCgroupNotFound}
+	if errs := mgr.DiscoveryErrors(); len(errs) != 2 || errs[barErr] != 1 || errs[fooErr] != 1 {
+		t.Errorf("expect errors of foo and bar, got %v", errs)
+	}
+
+	// Errors of pods gone are dropped.
+	mgr.podLister = &mockPodLister{pods: []*v1.Pod{bar}}
+	if err := mgr.resyncPods(source); err != nil {
+		t.Fatal(err)
+	}
+	if errs := mgr.DiscoveryErrors(); len(errs) != 1 || errs[barErr] != 1 {
+		t.Errorf("expect error of bar only, got %v", errs)
+	}
+}
+
+// fakeRuntime serves sandboxes of pods, methods not implemented panic.
+type fakeRuntime struct {
+	runtimeapi.RuntimeServiceServer
+	sandboxes []*runtimeapi.PodSandbox
+	pids      map[string]int
+	// netns are paths of network namespaces of sandboxes.
+	netns map[string]string
+}
+
+func (r *fakeRuntime) ListPodSandbox(ctx context.Context, req *runtimeapi.ListPodSandboxRequest) (*runtimeapi.ListPodSandboxResponse, error) {
+	return &runtimeapi.ListPodSandboxResponse{Items: r.sandboxes}, nil
+}
+
+func (r *fakeRuntime) PodSandboxStatus(ctx context.Context, req *runtimeapi.PodSandboxStatusRequest) (*runtimeapi.PodSandboxStatusResponse, error) {
+	pid, ok := r.pids[req.PodSandboxId]
+	if !ok {
+		return nil, fmt.Errorf("sandbox %v not found", req.PodSandboxId)
+	}
+	return &runtimeapi.PodSandboxStatusResponse{
+		Status: &runtimeapi.PodSandboxStatus{Id: req.PodSandboxId},
+		Info: map[string]string{"info": fmt.Sprintf(`{"pid":%d,"runtimeSpec":{"linux":{"namespaces":[{"type":"network","path":%q}]}}}`,
+			pid, r.netns[req.PodSandboxId])},
+	}, nil
+}
+
+func TestCRISource(t *testing.T) {
+	saved := hostRootfsPath
+	defer func() {
+		hostRootfsPath = saved
+	}()
+
+	tmpDir, err := ioutil.TempDir("", "kube-extra-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	hostRootfsPath = tmpDir
+
+	socket := path.Join(tmpDir, "/run/containerd/containerd.sock")
+	if err := os.MkdirAll(path.Dir(socket), 0777); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	runtimeapi.RegisterRuntimeServiceServer(server, &fakeRuntime{
+		sandboxes: []*runtimeapi.PodSandbox{
+			{
+				Id:       "sandbox-0",
+				Metadata: &runtimeapi.PodSandboxMetadata{Name: "foo", Uid: "1952d77-996a-11e9-81b0-0242ac110002", Attempt: 0},
+			},
+			{
+				Id:       "sandbox-1",
+				Metadata: &runtimeapi.PodSandboxMetadata{Name: "foo", Uid: "1952d77-996a-11e9-81b0-0242ac110002", Attempt: 1},
+			},
+			{
+				Id:       "sandbox-2",
+				Metadata: &runtimeapi.PodSandboxMetadata{Name: "bar", Uid: "2952d77-996a-11e9-81b0-0242ac110002", Attempt: 0},
+			},
+		},
+		pids: map[string]int{"sandbox-0": 10, "sandbox-1": 11, "sandbox-2": 12},
+		netns: map[string]string{
+			"sandbox-1": "/var/run/netns/cni-1",
+			"sandbox-2": "/var/run/netns/cni-2",
+		},
+	})
+
+	// Pid of sandbox-2 is reused by a process in another namespace.
+	for _, netns := range []string{"cni-1", "cni-2"} {
+		file := path.Join(tmpDir, "var/run/netns", netns)
+		if err := os.MkdirAll(path.Dir(file), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for pid, netns := range map[int]string{11: "cni-1", 12: "cni-1"} {
+		dir := path.Join(tmpDir, "proc", strconv.Itoa(pid), "ns")
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(path.Join(tmpDir, "var/run/netns", netns), path.Join(dir, "net")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	go server.Serve(l)
+	defer server.Stop()
+
+	pods := []*v1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "foo",
+				UID:  types.UID("1952d77-996a-11e9-81b0-0242ac110002"),
+			},
+			Status: v1.PodStatus{
+				QOSClass: v1.PodQOSGuaranteed,
+				Phase:    v1.PodRunning,
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "bar",
+				UID:  types.UID("2952d77-996a-11e9-81b0-0242ac110002"),
+			},
+			Status: v1.PodStatus{
+				QOSClass: v1.PodQOSGuaranteed,
+				Phase:    v1.PodRunning,
+			},
+		},
+	}
+	mgr, err := New(&mockPodLister{pods: pods}, Options{PidSource: PidSourceCRI})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := startManager(t, mgr)
+	defer stop()
+
+	mgr.containersLock.Lock()
+	defer mgr.containersLock.Unlock()
+	p, ok := mgr.pods["1952d77-996a-11e9-81b0-0242ac110002"]
+	if !ok {
+		t.Fatalf("pod not found, got %#v", mgr.pods)
+	}
+	if p.onePid() != 11 {
+		t.Errorf("expect pid of latest sandbox 11, got %v", p.onePid())
+	}
+	if _, ok := mgr.pods["2952d77-996a-11e9-81b0-0242ac110002"]; ok {
+		t.Errorf("expect bar not discovered with pid in another network namespace, got %#v", mgr.pods)
+	}
+}
+
+type countingStatsProvider struct {
+	lock sync.Mutex
+	pids []int
+	// blocked pids are read once unblock is closed.
+	blocked map[int]bool
+	unblock chan struct{}
+}
+
+func (p *countingStatsProvider) GetStats(rootFs string, pid int, owners network.SocketOwners) (*network.Stats, error) {
+	p.lock.Lock()
+	p.pids = append(p.pids, pid)
+	blocked := p.blocked[pid]
+	p.lock.Unlock()
+	if blocked {
+		<-p.unblock
+	}
+	return &network.Stats{}, nil
+}
+
+func (p *countingStatsProvider) TcpInfoEnabled() bool {
+	return false
+}
+
+func (p *countingStatsProvider) GetCounters(rootFs string, pid int) (network.Counters, error) {
+	return network.Counters{}, nil
+}
+
+func TestListStatsByNetns(t *testing.T) {
+	saved := hostRootfsPath
+	defer func() {
+		hostRootfsPath = saved
+	}()
+
+	tmpDir, err := ioutil.TempDir("", "kube-extra-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	hostRootfsPath = tmpDir
+
+	// pid 1 and 2 share a network namespace, pid 3 is in host one.
+	netns := map[int]string{1: "net:[4026532200]", 2: "net:[4026532200]", 3: "net:[4026531993]"}
+	for pid, id := range netns {
+		dir := path.Join(tmpDir, "proc", strconv.Itoa(pid), "ns")
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(id, path.Join(dir, "net")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Pids cgroup of c is read whether its network namespace is or not.
+	pidsCgroup := path.Join(tmpDir, "sys/fs/cgroup/pids/kubepods/podc")
+	if err := os.MkdirAll(pidsCgroup, 0777); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(path.Join(pidsCgroup, "pids.current"), []byte("3\n"), 0777)
+	ioutil.WriteFile(path.Join(pidsCgroup, "pids.max"), []byte("max\n"), 0777)
+
+	for _, exclude := range []bool{false, true} {
+		provider := &countingStatsProvider{}
+		mgr, err := New(&mockPodLister{}, Options{ExcludeHostNetwork: exclude})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mgr.networkStatsProvider = provider
+		mgr.countersProvider = provider
+		mgr.pods = map[string]*podData{
+			"a": {Name: "a", UID: "a", sandboxPid: 1},
+			"b": {Name: "b", UID: "b", sandboxPid: 2},
+			"c": {Name: "c", UID: "c", sandboxPid: 3, hostNetwork: true, pidsCgroup: pidsCgroup},
+		}
+
+		mgr.collect()
+		stats, err := mgr.ListStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectReads := 2
+		if exclude {
+			expectReads = 1
+		}
+		if len(stats) != 3 {
+			t.Errorf("exclude host network %v, expect 3 stats, got %v", exclude, len(stats))
+		}
+		if len(provider.pids) != expectReads {
+			t.Errorf("exclude host network %v, expect %v network namespaces read, got pids %v", exclude, expectReads, provider.pids)
+		}
+		for _, s := range stats {
+			if s.HostNetwork != (s.PodName == "c") {
+				t.Errorf("unexpected host network of %+v", s)
+			}
+			excluded := exclude && s.PodName == "c"
+			if (s.Network == nil) != excluded || s.CollectedAt.IsZero() != excluded {
+				t.Errorf("exclude host network %v, unexpected network stats of %+v", exclude, s)
+			}
+			if s.ProcessesCollectedAt.IsZero() {
+				t.Errorf("expect processes collection time of %v", s.PodName)
+			}
+			if s.PodName == "c" && (s.Pids == nil || s.Pids.Current != 3) {
+				t.Errorf("exclude host network %v, expect pids of c, got %+v", exclude, s.Pids)
+			}
+		}
+	}
+}
+
+func TestRunCollector(t *testing.T) {
+	saved := hostRootfsPath
+	defer func() {
+		hostRootfsPath = saved
+	}()
+
+	tmpDir, err := ioutil.TempDir("", "kube-extra-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	hostRootfsPath = tmpDir
+
+	dir := path.Join(tmpDir, "proc/1/ns")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("net:[4026532200]", path.Join(dir, "net")); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &countingStatsProvider{
+		blocked: map[int]bool{},
+		unblock: make(chan struct{}),
+	}
+	mgr, err := New(&mockPodLister{}, Options{CollectInterval: 10 * time.Millisecond, CollectTimeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr.networkStatsProvider = provider
+	mgr.countersProvider = provider
+	mgr.pods = map[string]*podData{
+		"a": {Name: "a", UID: "a", sandboxPid: 1},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		mgr.runCollector(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	reads := func() int {
+		provider.lock.Lock()
+		defer provider.lock.Unlock()
+		return len(provider.pids)
+	}
+
+	// Snapshots are published every interval.
+	err = wait.PollImmediate(10*time.Millisecond, time.Second, func() (bool, error) {
+		return reads() >= 3, nil
+	})
+	if err != nil {
+		t.Fatalf("expect collections every interval, got %v", reads())
+	}
+
+	// Scrapes get the previous snapshot without waiting for a collection
+	// hanging in reading.
+	provider.lock.Lock()
+	provider.blocked[1] = true
+	started := len(provider.pids)
+	blockedAt := time.Now()
+	provider.lock.Unlock()
+	defer close(provider.unblock)
+	err = wait.PollImmediate(10*time.Millisecond, time.Second, func() (bool, error) {
+		return reads() > started, nil
+	})
+	if err != nil {
+		t.Fatal("expect a collection reading")
+	}
+
+	listed := make(chan []*info.Stats, 1)
+	go func() {
+		stats, _ := mgr.ListStats()
+		listed <- stats
+	}()
+	select {
+	case stats := <-listed:
+		if len(stats) != 1 || stats[0].CollectedAt.After(blockedAt) {
+			t.Errorf("expect stats of previous collection, got %+v", stats)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect listing stats not blocked by collection")
+	}
+}
+
+func TestCollectTimeout(t *testing.T) {
+	saved := hostRootfsPath
+	defer func() {
+		hostRootfsPath = saved
+	}()
+
+	tmpDir, err := ioutil.TempDir("", "kube-extra-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	hostRootfsPath = tmpDir
+
+	netns := map[int]string{1: "net:[4026532200]", 2: "net:[4026532201]"}
+	for pid, id := range netns {
+		dir := path.Join(tmpDir, "proc", strconv.Itoa(pid), "ns")
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(id, path.Join(dir, "net")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	provider := &countingStatsProvider{
+		blocked: map[int]bool{},
+		unblock: make(chan struct{}),
+	}
+	mgr, err := New(&mockPodLister{}, Options{CollectWorkers: 2, CollectTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr.networkStatsProvider = provider
+	mgr.countersProvider = provider
+	mgr.pods = map[string]*podData{
+		"a": {Name: "a", UID: "a", sandboxPid: 1},
+		"b": {Name: "b", UID: "b", sandboxPid: 2},
+	}
+
+	statsOf := func() map[string]*info.Stats {
+		stats, err := mgr.ListStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		byName := map[string]*info.Stats{}
+		for _, s := range stats {
+			byName[s.PodName] = s
+		}
+		return byName
+	}
+
+	mgr.collect()
+	first := statsOf()
+	if len(first) != 2 || first["b"].Stale {
+		t.Fatalf("expect fresh stats of both pods, got %+v", first)
+	}
+
+	// b hangs, it serves stats of first collection and times out again
+	// until reading finishes.
+	provider.lock.Lock()
+	provider.blocked[2] = true
+	provider.lock.Unlock()
+	for i := 1; i <= 2; i++ {
+		mgr.collect()
+		stats := statsOf()
+		if len(stats) != 2 {
+			t.Fatalf("expect stats of both pods, got %+v", stats)
+		}
+		if stats["a"].Stale {
+			t.Errorf("expect a not stale")
+		}
+		if !stats["b"].Stale || !stats["b"].CollectedAt.Equal(first["b"].CollectedAt) {
+			t.Errorf("expect stale stats of first collection for b, got %+v", stats["b"])
+		}
+		timeouts := mgr.CollectTimeouts()
+		if timeouts[info.PodRef{PodName: "b"}] != uint64(i) || timeouts[info.PodRef{PodName: "a"}] != 0 {
+			t.Errorf("expect b timed out %v times, got %v", i, timeouts)
+		}
+	}
+
+	close(provider.unblock)
+	err = wait.PollImmediate(10*time.Millisecond, time.Second, func() (bool, error) {
+		mgr.collect()
+		return !statsOf()["b"].Stale, nil
+	})
+	if err != nil {
+		t.Errorf("expect b fresh once reading finishes")
+	}
+}
+
+func TestSocketOwners(t *testing.T) {
+	saved := hostRootfsPath
+	defer func() {
+		hostRootfsPath = saved
+	}()
+
+	tmpDir, err := ioutil.TempDir("", "kube-extra-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	hostRootfsPath = tmpDir
+
+	fds := map[int][]string{
+		1: {"/dev/null", "socket:[20931]"},
+		2: {"socket:[33515]", "pipe:[33520]", "anon_inode:[eventpoll]", "socket:[33517]"},
+		3: {"socket:[33516]"},
+		5: {"/dev/null"},
+	}
+	for pid, targets := range fds {
+		dir := path.Join(tmpDir, "proc", strconv.Itoa(pid), "fd")
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+		for fd, target := range targets {
+			if err := os.Symlink(target, path.Join(dir, strconv.Itoa(fd))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// pid 2 is started after discovery and pid 4 is gone.
+	procs := map[string]string{"istio-proxy": "5\n1\n2\n4\n", "app": "3\n"}
+	cgroups := map[string]string{}
+	for name, content := range procs {
+		cgroup := path.Join(tmpDir, "sys/fs/cgroup/cpu/kubepods", name)
+		if err := os.MkdirAll(cgroup, 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(cgroup, "cgroup.procs"), []byte(content), 0777); err != nil {
+			t.Fatal(err)
+		}
+		cgroups[name] = cgroup
+	}
+	pod := &podData{
+		UID: "1952d77-996a-11e9-81b0-0242ac110002",
+		Containers: []*containerData{
+			{Name: "istio-proxy", Pids: []int{1}, cgroupPath: cgroups["istio-proxy"]},
+			{Name: "app", Pids: []int{3}, cgroupPath: cgroups["app"]},
+		},
+	}
+	proxy := network.SocketOwner{PodUID: pod.UID, Container: "istio-proxy"}
+	app := network.SocketOwner{PodUID: pod.UID, Container: "app"}
+	expect := network.SocketOwners{20931: proxy, 33515: proxy, 33517: proxy, 33516: app}
+	if owners := socketOwners([]*podData{pod}, false, 0); !reflect.DeepEqual(expect, owners) {
+		t.Errorf("expect %v, got %v", expect, owners)
+	}
+
+	// Commands of istio-proxy with fewer sockets are counted as other, sh
+	// owns no socket and takes no place.
+	comms := map[int]string{1: "envoy", 2: "pilot-agent", 3: "php-fpm", 5: "sh"}
+	for pid, comm := range comms {
+		ioutil.WriteFile(path.Join(tmpDir, "proc", strconv.Itoa(pid), "comm"), []byte(comm+"\n"), 0777)
+	}
+	pilotAgent := network.SocketOwner{PodUID: pod.UID, Container: "istio-proxy", Command: "pilot-agent"}
+	other := network.SocketOwner{PodUID: pod.UID, Container: "istio-proxy", Command: otherCommand}
+	phpFpm := network.SocketOwner{PodUID: pod.UID, Container: "app", Command: "php-fpm"}
+	expect = network.SocketOwners{20931: other, 33515: pilotAgent, 33517: pilotAgent, 33516: phpFpm}
+	if owners := socketOwners([]*podData{pod}, true, 1); !reflect.DeepEqual(expect, owners) {
+		t.Errorf("expect %v, got %v", expect, owners)
+	}
+	envoy := network.SocketOwner{PodUID: pod.UID, Container: "istio-proxy", Command: "envoy"}
+	expect = network.SocketOwners{20931: envoy, 33515: pilotAgent, 33517: pilotAgent, 33516: phpFpm}
+	if owners := socketOwners([]*podData{pod}, true, 2); !reflect.DeepEqual(expect, owners) {
+		t.Errorf("expect %v, got %v", expect, owners)
+	}
+	byCommand := &network.Stats{Owners: map[network.SocketOwner]network.TcpStat{
+		envoy:  {Established: 2},
+		other:  {Listen: 1},
+		phpFpm: {CloseWait: 3},
+	}}
+	expectCommands := []info.CommandStats{
+		{Container: "app", Command: "php-fpm", Tcp: network.TcpStat{CloseWait: 3}},
+		{Container: "istio-proxy", Command: "envoy", Tcp: network.TcpStat{Established: 2}},
+		{Container: "istio-proxy", Command: otherCommand, Tcp: network.TcpStat{Listen: 1}},
+	}
+	s := &info.Stats{Containers: newContainerStats(pod)}
+	setNetnsStats(s, pod, &netnsStats{network: byCommand}, true)
+	if !reflect.DeepEqual(expectCommands, s.Commands) {
+		t.Errorf("expect %+v, got %+v", expectCommands, s.Commands)
+	}
+	expectStats := []info.ContainerStats{
+		{Name: "istio-proxy", Tcp: &network.TcpStat{Established: 2, Listen: 1}},
+		{Name: "app", Tcp: &network.TcpStat{CloseWait: 3}},
+	}
+	if !reflect.DeepEqual(expectStats, s.Containers) {
+		t.Errorf("expect %+v, got %+v", expectStats, s.Containers)
+	}
+
+	netStat := &network.Stats{Owners: map[network.SocketOwner]network.TcpStat{
+		proxy: {Established: 2},
+	}}
+	fdStats := &info.FdStats{Open: 3}
+	expectStats = []info.ContainerStats{
+		{Name: "istio-proxy", Tcp: &network.TcpStat{Established: 2}, Fds: fdStats},
+		{Name: "app", Tcp: &network.TcpStat{}},
+	}
+	s = &info.Stats{Containers: newContainerStats(pod)}
+	setNetnsStats(s, pod, &netnsStats{network: netStat}, false)
+	setProcStats(s, pod, &podProcStats{
+		containers: map[*containerData]*containerProcStats{
+			pod.Containers[0]: {fds: fdStats},
+		},
+	})
+	if !reflect.DeepEqual(expectStats, s.Containers) || s.Commands != nil {
+		t.Errorf("expect %+v, got %+v", expectStats, s)
+	}
+	s = &info.Stats{Containers: newContainerStats(pod)}
+	setNetnsStats(s, pod, &netnsStats{network: &network.Stats{}}, false)
+	if len(s.Containers) != 2 || s.Containers[0].Tcp != nil || s.Containers[1].Tcp != nil {
+		t.Errorf("expect no tcp of containers unless sockets are attributed, got %+v", s.Containers)
+	}
+}
+
+func TestFdStats(t *testing.T) {
+	saved := hostRootfsPath
+	defer func() {
+		hostRootfsPath = saved
+	}()
+
+	tmpDir, err := ioutil.TempDir("", "kube-extra-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	hostRootfsPath = tmpDir
+
+	limits := `Limit                     Soft Limit           Hard Limit           Units
+Max cpu time              unlimited            unlimited            seconds
+Max open files            %v                 %v                 files
+Max locked memory         65536                65536                bytes
+`
+	// pid 11 is a thread of process 10.
+	procs := []struct {
+		pid, tgid  int
+		fds        int
+		soft, hard string
+	}{
+		{pid: 10, tgid: 10, fds: 3, soft: "4", hard: "unlimited"},
+		{pid: 11, tgid: 10, fds: 3, soft: "4", hard: "unlimited"},
+		{pid: 12, tgid: 12, fds: 2, soft: "1024", hard: "4096"},
+	}
+	for _, proc := range procs {
+		dir := path.Join(tmpDir, "proc", strconv.Itoa(proc.pid))
+		if err := os.MkdirAll(path.Join(dir, "fd"), 0777); err != nil {
+			t.Fatal(err)
+		}
+		for fd := 0; fd < proc.fds; fd++ {
+			if err := os.Symlink("/dev/null", path.Join(dir, "fd", strconv.Itoa(fd))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ioutil.WriteFile(path.Join(dir, "status"), []byte(fmt.Sprintf("Name:\tphp-fpm\nTgid:\t%v\nPid:\t%v\n", proc.tgid, proc.pid)), 0777)
+		ioutil.WriteFile(path.Join(dir, "limits"), []byte(fmt.Sprintf(limits, proc.soft, proc.hard)), 0777)
+	}
+
+	// pid 13 is gone.
+	expect := &info.FdStats{
+		Open:        5,
+		SoftLimit:   4,
+		HardLimit:   4096,
+		Utilisation: 0.75,
+	}
+	if stats := readFdStats(processes([]int{10, 11, 12, 13})); !reflect.DeepEqual(expect, stats) {
+		t.Errorf("expect %+v, got %+v", expect, stats)
+	}
+	if stats := readFdStats([]int{13}); stats != nil {
+		t.Errorf("expect no stats of processes gone, got %+v", stats)
+	}
+}
+
 func TestParseContainerID(t *testing.T) {
 	ID := "docker://999a54e3e9eb3c1bf58c96788850aa03a47d3e3c009da9ecae8d2edfdba5a328"
 	result := parseContainerID(ID)
@@ -145,3 +1244,109 @@ func TestParseContainerID(t *testing.T) {
 		t.Errorf("expect %s, got %s", expect, result)
 	}
 }
+
+func TestPidStats(t *testing.T) {
+	saved := hostRootfsPath
+	defer func() {
+		hostRootfsPath = saved
+	}()
+
+	tmpDir, err := ioutil.TempDir("", "kube-extra-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	hostRootfsPath = tmpDir
+
+	// Process 10 has threads 10 and 11. Its child 12 is a zombie, which may
+	// be dropped from cgroup.procs, and child 14 is moved to another cgroup.
+	procs := []struct {
+		pid      int
+		stat     string
+		children map[int]string
+	}{
+		{pid: 10, stat: "10 (php-fpm: pool (www)) S 1 10 10 0 -1 4194560 100 0 0 0 5 3 0 0 20 0 2 0 100 0 0",
+			children: map[int]string{10: "12 14 ", 11: ""}},
+		{pid: 12, stat: "12 (php-fpm) Z 10 10 10 0 -1 4227084 0 0 0 0 0 0 0 0 20 0 1 0 120 0 0"},
+		{pid: 14, stat: "14 (sleep) S 10 10 10 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 1 0 130 0 0"},
+	}
+	for _, proc := range procs {
+		dir := path.Join(tmpDir, "proc", strconv.Itoa(proc.pid))
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+		ioutil.WriteFile(path.Join(dir, "stat"), []byte(proc.stat+"\n"), 0777)
+		for tid, children := range proc.children {
+			task := path.Join(dir, "task", strconv.Itoa(tid))
+			if err := os.MkdirAll(task, 0777); err != nil {
+				t.Fatal(err)
+			}
+			ioutil.WriteFile(path.Join(task, "children"), []byte(children), 0777)
+		}
+	}
+
+	expect := &info.ProcessStats{Processes: 2, Threads: 3, Zombies: 1}
+	for _, procs := range [][]int{{10}, {10, 12, 13}} {
+		if stats := readProcessStats(procs); !reflect.DeepEqual(expect, stats) {
+			t.Errorf("processes %v, expect %+v, got %+v", procs, expect, stats)
+		}
+	}
+	if stats := readProcessStats([]int{13}); stats != nil {
+		t.Errorf("expect no stats of processes gone, got %+v", stats)
+	}
+
+	cases := []struct {
+		max    string
+		expect *info.PidsCgroupStats
+	}{
+		{max: "max\n", expect: &info.PidsCgroupStats{Current: 3, Max: math.Inf(1)}},
+		{max: "1024\n", expect: &info.PidsCgroupStats{Current: 3, Max: 1024}},
+	}
+	for _, cas := range cases {
+		cgroup := path.Join(tmpDir, "sys/fs/cgroup/pids/kubepods/pod1952d77-996a-11e9-81b0-0242ac110002")
+		if err := os.MkdirAll(cgroup, 0777); err != nil {
+			t.Fatal(err)
+		}
+		ioutil.WriteFile(path.Join(cgroup, "pids.current"), []byte("3\n"), 0777)
+		ioutil.WriteFile(path.Join(cgroup, "pids.max"), []byte(cas.max), 0777)
+		if stats := readPidsCgroup(cgroup); !reflect.DeepEqual(cas.expect, stats) {
+			t.Errorf("pids.max %q, expect %+v, got %+v", cas.max, cas.expect, stats)
+		}
+	}
+	if stats := readPidsCgroup(""); stats != nil {
+		t.Errorf("expect no stats of unknown cgroup, got %+v", stats)
+	}
+}
+
+func TestPressure(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "kube-extra-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// cpu.pressure has no full line before linux 5.13, io.pressure is not
+	// readable.
+	files := map[string]string{
+		"cpu.pressure":    "some avg10=1.50 avg60=0.80 avg300=0.25 total=1234567\n",
+		"memory.pressure": "some avg10=0.00 avg60=0.00 avg300=0.00 total=42\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=7\n",
+	}
+	for name, content := range files {
+		ioutil.WriteFile(path.Join(tmpDir, name), []byte(content), 0777)
+	}
+
+	expect := []info.PressureStats{
+		{Resource: "cpu", Kind: "some", Avg10: 1.5, Avg60: 0.8, Avg300: 0.25, Total: 1234567},
+		{Resource: "memory", Kind: "some", Total: 42},
+		{Resource: "memory", Kind: "full", Total: 7},
+	}
+	if stats := readPressure(tmpDir); !reflect.DeepEqual(expect, stats) {
+		t.Errorf("expect %+v, got %+v", expect, stats)
+	}
+	if stats := readPressure(""); stats != nil {
+		t.Errorf("expect no pressure of unknown cgroup, got %+v", stats)
+	}
+	if _, err := parsePressure("cpu", "some avg10=1.50 avg60=0.80\n"); err == nil {
+		t.Error("expect error for truncated pressure line")
+	}
+}
@@ -0,0 +1,142 @@
+package manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/caitong93/kube-extra-exporter/pkg/info"
+)
+
+// readPidsCgroup reads pids.current and pids.max of cgroup in pids hierarchy,
+// nil if cgroup is unknown or not readable.
+func readPidsCgroup(cgroupPath string) *info.PidsCgroupStats {
+	if cgroupPath == "" {
+		return nil
+	}
+	current, err := ioutil.ReadFile(path.Join(cgroupPath, "pids.current"))
+	if err != nil {
+		return nil
+	}
+	max, err := ioutil.ReadFile(path.Join(cgroupPath, "pids.max"))
+	if err != nil {
+		return nil
+	}
+
+	stats := &info.PidsCgroupStats{Max: math.Inf(1)}
+	stats.Current, err = strconv.ParseUint(strings.TrimSpace(string(current)), 10, 64)
+	if err != nil {
+		return nil
+	}
+	if s := strings.TrimSpace(string(max)); s != "max" {
+		v, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil
+		}
+		stats.Max = float64(v)
+	}
+	return stats
+}
+
+// readProcessStats counts processes procs and their threads and zombies, nil
+// if no process is readable. Exited processes may be dropped from
+// cgroup.procs before they are reaped, so zombies are looked for among
+// children of procs too.
+func readProcessStats(procs []int) *info.ProcessStats {
+	var stats *info.ProcessStats
+	seen := make(map[int]bool, len(procs))
+	for _, pid := range procs {
+		seen[pid] = true
+	}
+	for _, pid := range procs {
+		state, threads, err := readProcStat(pid)
+		if err != nil {
+			continue
+		}
+		if stats == nil {
+			stats = &info.ProcessStats{}
+		}
+		stats.Processes++
+		stats.Threads += threads
+		if state == "Z" {
+			stats.Zombies++
+		}
+
+		for _, child := range readChildren(pid) {
+			if seen[child] {
+				continue
+			}
+			seen[child] = true
+			// Children alive are in procs unless they are moved to
+			// other cgroups.
+			state, threads, err := readProcStat(child)
+			if err != nil || state != "Z" {
+				continue
+			}
+			stats.Processes++
+			stats.Threads += threads
+			stats.Zombies++
+		}
+	}
+	return stats
+}
+
+// readChildren reads children of all threads of pid, nil if not readable,
+// e.g. kernel is built without CONFIG_PROC_CHILDREN.
+func readChildren(pid int) []int {
+	dir := path.Join(hostRootfsPath, "proc", strconv.Itoa(pid), "task")
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil
+	}
+	tids, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil
+	}
+
+	var children []int
+	for _, tid := range tids {
+		data, err := ioutil.ReadFile(path.Join(dir, tid, "children"))
+		if err != nil {
+			continue
+		}
+		for _, s := range strings.Fields(string(data)) {
+			child, err := strconv.Atoi(s)
+			if err != nil {
+				continue
+			}
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// readProcStat reads state and number of threads of pid from its stat.
+// Format: 1 (systemd) S 0 1 1 0 -1 4194560 ... , the 3rd field is state
+// and the 20th is num_threads. Command name may contain spaces and
+// parentheses, fields are counted after the last parenthesis.
+func readProcStat(pid int) (string, uint64, error) {
+	data, err := ioutil.ReadFile(path.Join(hostRootfsPath, "proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return "", 0, err
+	}
+	stat := string(data)
+	i := strings.LastIndex(stat, ")")
+	if i < 0 {
+		return "", 0, fmt.Errorf("invalid stat of pid %v: %v", pid, stat)
+	}
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) < 18 {
+		return "", 0, fmt.Errorf("invalid stat of pid %v: %v", pid, stat)
+	}
+	threads, err := strconv.ParseUint(fields[17], 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid stat of pid %v: %v", pid, err)
+	}
+	return fields[0], threads, nil
+}
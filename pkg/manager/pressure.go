@@ -0,0 +1,79 @@
+package manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/caitong93/kube-extra-exporter/pkg/info"
+)
+
+// pressureResources have <resource>.pressure files in cgroups of v2.
+var pressureResources = []string{"cpu", "memory", "io"}
+
+// readPressure reads pressure stall information of cgroup in unified
+// hierarchy, nil if cgroup is unknown or PSI is disabled. Resources not
+// readable are skipped.
+func readPressure(cgroupPath string) []info.PressureStats {
+	if cgroupPath == "" {
+		return nil
+	}
+	var stats []info.PressureStats
+	for _, resource := range pressureResources {
+		data, err := ioutil.ReadFile(path.Join(cgroupPath, resource+".pressure"))
+		if err != nil {
+			continue
+		}
+		lines, err := parsePressure(resource, string(data))
+		if err != nil {
+			continue
+		}
+		stats = append(stats, lines...)
+	}
+	return stats
+}
+
+// parsePressure parses content of a pressure file, full line is missing in
+// cpu.pressure of kernels before 5.13.
+// Format:
+// some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+// full avg10=0.00 avg60=0.00 avg300=0.00 total=0
+func parsePressure(resource, content string) ([]info.PressureStats, error) {
+	stats := []info.PressureStats{}
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("invalid pressure line: %v", line)
+		}
+
+		s := info.PressureStats{Resource: resource, Kind: fields[0]}
+		for _, field := range fields[1:] {
+			i := strings.Index(field, "=")
+			if i < 0 {
+				return nil, fmt.Errorf("invalid pressure line: %v", line)
+			}
+			key, value := field[:i], field[i+1:]
+			var err error
+			switch key {
+			case "avg10":
+				s.Avg10, err = strconv.ParseFloat(value, 64)
+			case "avg60":
+				s.Avg60, err = strconv.ParseFloat(value, 64)
+			case "avg300":
+				s.Avg300, err = strconv.ParseFloat(value, 64)
+			case "total":
+				s.Total, err = strconv.ParseUint(value, 10, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid pressure line %v: %v", line, err)
+			}
+		}
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
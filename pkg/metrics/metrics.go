@@ -1,8 +1,14 @@
 package metrics
 
 import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
 	"github.com/caicloud/nirvana/log"
 	"github.com/caitong93/kube-extra-exporter/pkg/info"
+	"github.com/caitong93/kube-extra-exporter/pkg/network"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -21,27 +27,124 @@ type metricValues []metricValue
 type metricValue struct {
 	value  float64
 	labels []string
+	// histogram is set instead of value by histogram metrics.
+	histogram *network.Histogram
 }
 
 type infoProvider interface {
 	ListStats() ([]*info.Stats, error)
+	DiscoveryErrors() map[info.DiscoveryError]uint64
+	CollectTimeouts() map[info.PodRef]uint64
+	TcpInfoEnabled() bool
 }
 
 // PrometheusCollector implements prometheus.Collector.
 type PrometheusCollector struct {
-	infoProvider infoProvider
-	errors       prometheus.Gauge
-	podMetrics   []podMetric
+	infoProvider    infoProvider
+	errors          prometheus.Gauge
+	discoveryErrors *prometheus.Desc
+	collectTimeouts *prometheus.Desc
+	tcpInfoEnabled  *prometheus.Desc
+	// freshness are exported without collection timestamp unlike podMetrics.
+	freshness []podMetric
+	// podMetrics are of network namespace of pod, they are timestamped with
+	// its collection time.
+	podMetrics []podMetric
+	// processMetrics are of processes and cgroups of pod, which are
+	// collected apart from its network namespace.
+	processMetrics []podMetric
+}
+
+// DefaultNetstatFields are the protocol counters exported when no fields are configured.
+var DefaultNetstatFields = []string{
+	"TcpActiveOpens",
+	"TcpPassiveOpens",
+	"TcpAttemptFails",
+	"TcpEstabResets",
+	"TcpRetransSegs",
+	"TcpInErrs",
+	"TcpOutRsts",
+	"TcpExtListenOverflows",
+	"TcpExtListenDrops",
+	"TcpExtTCPTimeouts",
+	"UdpInErrors",
+	"UdpNoPorts",
+	"UdpRcvbufErrors",
+	"UdpSndbufErrors",
+}
+
+// netstatFieldPattern matches fields valid in metric names, as in
+// /proc/net/netstat prefixed with their protocol, e.g. TcpExtListenDrops.
+var netstatFieldPattern = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+// CheckNetstatFields removes duplicated fields and checks they are valid in
+// metric names, a bad field would fail registering the collector.
+func CheckNetstatFields(fields []string) ([]string, error) {
+	checked := make([]string, 0, len(fields))
+	seen := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		if !netstatFieldPattern.MatchString(field) {
+			return nil, fmt.Errorf("invalid netstat field %q", field)
+		}
+		if seen[field] {
+			continue
+		}
+		seen[field] = true
+		checked = append(checked, field)
+	}
+	return checked, nil
 }
 
-func NewPrometheusCollector(i infoProvider) *PrometheusCollector {
-	return &PrometheusCollector{
+// NewPrometheusCollector creates a collector, netstatFields are names of the
+// /proc/net/snmp and /proc/net/netstat counters to export, e.g. TcpRetransSegs.
+func NewPrometheusCollector(i infoProvider, netstatFields []string) *PrometheusCollector {
+	c := &PrometheusCollector{
 		infoProvider: i,
 		errors: prometheus.NewGauge(prometheus.GaugeOpts{
 			Namespace: "container",
 			Name:      "scrape_error",
 			Help:      "1 if there was an error while getting container metrics, 0 otherwise",
 		}),
+		discoveryErrors: prometheus.NewDesc(
+			"exporter_pod_discovery_errors_total",
+			"Failures finding processes of pod, pods failing keep stats of their last discovery",
+			[]string{"namespace", "pod", "reason"}, nil),
+		collectTimeouts: prometheus.NewDesc(
+			"exporter_pod_collect_timeouts_total",
+			"Times stats of pod were not read in time, pods timed out serve stats of an earlier collection",
+			[]string{"namespace", "pod"}, nil),
+		tcpInfoEnabled: prometheus.NewDesc(
+			"exporter_tcp_info_enabled",
+			"1 if tcp_info histograms are collected, 0 if not requested or netlink backend fell back to proc",
+			nil, nil),
+		freshness: []podMetric{
+			{
+				name:      "pod_stats_staleness_seconds",
+				help:      "Seconds since the oldest stats of pod were collected, series of pod carry their collection time",
+				valueType: prometheus.GaugeValue,
+				getValues: func(s *info.Stats) metricValues {
+					oldest := s.ProcessesCollectedAt
+					if s.Network != nil && (oldest.IsZero() || s.CollectedAt.Before(oldest)) {
+						oldest = s.CollectedAt
+					}
+					if oldest.IsZero() {
+						return nil
+					}
+					return metricValues{{value: time.Since(oldest).Seconds()}}
+				},
+			},
+			{
+				name:      "pod_stats_stale",
+				help:      "1 if stats of pod are of an earlier collection since reading it timed out, 0 otherwise",
+				valueType: prometheus.GaugeValue,
+				getValues: func(s *info.Stats) metricValues {
+					if s.Stale {
+						return metricValues{{value: 1}}
+					}
+					return metricValues{{value: 0}}
+				},
+			},
+		},
 		podMetrics: []podMetric{
 			{
 				name:        "pod_tcp_connections",
@@ -142,6 +245,487 @@ func NewPrometheusCollector(i infoProvider) *PrometheusCollector {
 					}
 				},
 			},
+			{
+				name:        "pod_udp_sockets",
+				help:        "udp(include udp6) sockets usage statistic for pod",
+				valueType:   prometheus.GaugeValue,
+				extraLabels: []string{"udp_state", "proto"},
+				getValues: func(s *info.Stats) metricValues {
+					return metricValues{
+						{
+							value:  float64(s.Network.Udp.Bound),
+							labels: []string{"bound", "udp"},
+						},
+						{
+							value:  float64(s.Network.Udp.Connected),
+							labels: []string{"connected", "udp"},
+						},
+						{
+							value:  float64(s.Network.Udp6.Bound),
+							labels: []string{"bound", "udp6"},
+						},
+						{
+							value:  float64(s.Network.Udp6.Connected),
+							labels: []string{"connected", "udp6"},
+						},
+					}
+				},
+			},
+			{
+				name:        "pod_udp_drops_total",
+				help:        "datagrams dropped by udp(include udp6) sockets of pod",
+				valueType:   prometheus.CounterValue,
+				extraLabels: []string{"proto"},
+				getValues: func(s *info.Stats) metricValues {
+					return metricValues{
+						{
+							value:  float64(s.Network.Udp.Drops),
+							labels: []string{"udp"},
+						},
+						{
+							value:  float64(s.Network.Udp6.Drops),
+							labels: []string{"udp6"},
+						},
+					}
+				},
+			},
+		},
+	}
+
+	c.podMetrics = append(c.podMetrics,
+		interfaceMetric("pod_network_receive_bytes_total", "bytes received", func(i *network.InterfaceStat) uint64 { return i.RxBytes }),
+		interfaceMetric("pod_network_receive_packets_total", "packets received", func(i *network.InterfaceStat) uint64 { return i.RxPackets }),
+		interfaceMetric("pod_network_receive_errors_total", "errors encountered while receiving", func(i *network.InterfaceStat) uint64 { return i.RxErrors }),
+		interfaceMetric("pod_network_receive_packets_dropped_total", "packets dropped while receiving", func(i *network.InterfaceStat) uint64 { return i.RxDropped }),
+		interfaceMetric("pod_network_receive_fifo_errors_total", "fifo buffer errors while receiving", func(i *network.InterfaceStat) uint64 { return i.RxFifo }),
+		interfaceMetric("pod_network_receive_frame_errors_total", "frame alignment errors while receiving", func(i *network.InterfaceStat) uint64 { return i.RxFrame }),
+		interfaceMetric("pod_network_transmit_bytes_total", "bytes transmitted", func(i *network.InterfaceStat) uint64 { return i.TxBytes }),
+		interfaceMetric("pod_network_transmit_packets_total", "packets transmitted", func(i *network.InterfaceStat) uint64 { return i.TxPackets }),
+		interfaceMetric("pod_network_transmit_errors_total", "errors encountered while transmitting", func(i *network.InterfaceStat) uint64 { return i.TxErrors }),
+		interfaceMetric("pod_network_transmit_packets_dropped_total", "packets dropped while transmitting", func(i *network.InterfaceStat) uint64 { return i.TxDropped }),
+		interfaceMetric("pod_network_transmit_fifo_errors_total", "fifo buffer errors while transmitting", func(i *network.InterfaceStat) uint64 { return i.TxFifo }),
+	)
+
+	c.podMetrics = append(c.podMetrics, podMetric{
+		name:        "pod_tcp_remote_connections",
+		help:        "outbound tcp(include tcp6) connections of pod by remote endpoint in every state, connections accepted on listening ports are not counted, only top remote endpoints are reported",
+		valueType:   prometheus.GaugeValue,
+		extraLabels: []string{"remote_ip", "remote_port", "state", "remote_kind", "remote_namespace", "remote_workload", "remote_service", "remote_node"},
+		getValues: func(s *info.Stats) metricValues {
+			values := metricValues{}
+			for i := range s.Network.Remotes {
+				remote := &s.Network.Remotes[i]
+				port := strconv.Itoa(int(remote.Port))
+				// Endpoint is empty if resolving is disabled.
+				endpoint := s.RemoteEndpoints[remote.IP]
+				for _, state := range remote.Tcp.States() {
+					if state.Count == 0 {
+						continue
+					}
+					values = append(values, metricValue{
+						value: float64(state.Count),
+						labels: []string{remote.IP, port, state.State,
+							endpoint.Kind, endpoint.Namespace, endpoint.Workload, endpoint.Service, endpoint.Node},
+					})
+				}
+			}
+			return values
+		},
+	})
+
+	c.podMetrics = append(c.podMetrics, podMetric{
+		name:        "pod_listening_port",
+		help:        "1 for every port pod has tcp listening or unconnected udp sockets on, udp sockets on ephemeral ports are left out, declared tells whether the port is in spec.containers[].ports",
+		valueType:   prometheus.GaugeValue,
+		extraLabels: []string{"port", "proto", "declared"},
+		getValues: func(s *info.Stats) metricValues {
+			values := make(metricValues, 0, len(s.Network.Listening))
+			for _, listen := range s.Network.Listening {
+				declared := false
+				for _, port := range s.DeclaredPorts {
+					if port.Proto == listen.Proto && port.Port == listen.Port {
+						declared = true
+						break
+					}
+				}
+				values = append(values, metricValue{
+					value:  1,
+					labels: []string{strconv.Itoa(int(listen.Port)), listen.Proto, strconv.FormatBool(declared)},
+				})
+			}
+			return values
+		},
+	}, podMetric{
+		name:        "pod_declared_port_not_listening",
+		help:        "1 if nothing in pod listens on a port declared in spec.containers[].ports, 0 otherwise",
+		valueType:   prometheus.GaugeValue,
+		extraLabels: []string{"container", "port", "proto"},
+		getValues: func(s *info.Stats) metricValues {
+			values := make(metricValues, 0, len(s.DeclaredPorts))
+			for _, port := range s.DeclaredPorts {
+				// Listening sockets of sctp are not walked, udp ones on
+				// ephemeral ports are not told from clients.
+				if port.Proto != "tcp" && port.Proto != "udp" {
+					continue
+				}
+				if port.Proto == "udp" && network.IsEphemeralPort(port.Port) {
+					continue
+				}
+				missing := 1.0
+				for _, listen := range s.Network.Listening {
+					if port.Proto == listen.Proto && port.Port == listen.Port {
+						missing = 0
+						break
+					}
+				}
+				values = append(values, metricValue{
+					value:  missing,
+					labels: []string{port.Container, strconv.Itoa(int(port.Port)), port.Proto},
+				})
+			}
+			return values
+		},
+	})
+
+	c.podMetrics = append(c.podMetrics, podMetric{
+		name:        "pod_tcp_listen_backlog",
+		help:        "tcp connections established but not accepted yet by listening port of pod",
+		valueType:   prometheus.GaugeValue,
+		extraLabels: []string{"listen_port"},
+		getValues: func(s *info.Stats) metricValues {
+			values := make(metricValues, 0, len(s.Network.Backlogs))
+			for _, backlog := range s.Network.Backlogs {
+				values = append(values, metricValue{
+					value:  float64(backlog.Queued),
+					labels: []string{strconv.Itoa(int(backlog.Port))},
+				})
+			}
+			return values
+		},
+	}, podMetric{
+		name:        "pod_tcp_listen_backlog_max",
+		help:        "limit of not accepted tcp connections by listening port of pod, only reported by netlink backend",
+		valueType:   prometheus.GaugeValue,
+		extraLabels: []string{"listen_port"},
+		getValues: func(s *info.Stats) metricValues {
+			values := make(metricValues, 0, len(s.Network.Backlogs))
+			for _, backlog := range s.Network.Backlogs {
+				if backlog.Max == 0 {
+					continue
+				}
+				values = append(values, metricValue{
+					value:  float64(backlog.Max),
+					labels: []string{strconv.Itoa(int(backlog.Port))},
+				})
+			}
+			return values
+		},
+	}, podMetric{
+		name:      "pod_net_core_somaxconn",
+		help:      "net.core.somaxconn of pod network namespace, the upper bound of listen backlog",
+		valueType: prometheus.GaugeValue,
+		getValues: func(s *info.Stats) metricValues {
+			// Reading somaxconn needs privileges to enter network namespace.
+			if s.Network.Somaxconn == 0 {
+				return nil
+			}
+			return metricValues{{value: float64(s.Network.Somaxconn)}}
+		},
+	}, podMetric{
+		name:        "pod_tcp_queued_bytes",
+		help:        "bytes queued in tcp(include tcp6) connections of pod, receive is not read by application and send is not acknowledged by peer",
+		valueType:   prometheus.GaugeValue,
+		extraLabels: []string{"queue"},
+		getValues: func(s *info.Stats) metricValues {
+			return metricValues{
+				{
+					value:  float64(s.Network.RecvQueued),
+					labels: []string{"receive"},
+				},
+				{
+					value:  float64(s.Network.SendQueued),
+					labels: []string{"send"},
+				},
+			}
+		},
+	})
+
+	c.podMetrics = append(c.podMetrics, podMetric{
+		name:        "container_tcp_connections",
+		help:        "tcp(include tcp6) connections of sockets opened by processes of container, only reported if sockets are attributed to containers",
+		valueType:   prometheus.GaugeValue,
+		extraLabels: []string{"container", "tcp_state"},
+		getValues: func(s *info.Stats) metricValues {
+			values := metricValues{}
+			for i := range s.Containers {
+				cont := &s.Containers[i]
+				if cont.Tcp == nil {
+					continue
+				}
+				for _, state := range cont.Tcp.States() {
+					values = append(values, metricValue{
+						value:  float64(state.Count),
+						labels: []string{cont.Name, state.State},
+					})
+				}
+			}
+			return values
+		},
+	})
+
+	c.podMetrics = append(c.podMetrics, podMetric{
+		name:        "container_command_tcp_connections",
+		help:        "tcp(include tcp6) connections of sockets opened by processes of a command in container, only reported if sockets are attributed to processes",
+		valueType:   prometheus.GaugeValue,
+		extraLabels: []string{"container", "command", "tcp_state"},
+		getValues: func(s *info.Stats) metricValues {
+			values := metricValues{}
+			for i := range s.Commands {
+				cmd := &s.Commands[i]
+				for _, state := range cmd.Tcp.States() {
+					if state.Count == 0 {
+						continue
+					}
+					values = append(values, metricValue{
+						value:  float64(state.Count),
+						labels: []string{cmd.Container, cmd.Command, state.State},
+					})
+				}
+			}
+			return values
+		},
+	})
+
+	c.processMetrics = append(c.processMetrics,
+		containerMetric("container_open_fds", "fds opened by all processes of container", func(c *info.ContainerStats) (float64, bool) {
+			return fdsValue(c, func(f *info.FdStats) float64 { return float64(f.Open) })
+		}),
+		containerMetric("container_fd_utilisation_ratio", "the highest ratio of open fds to soft max open files of a process of container, EMFILE happens at 1", func(c *info.ContainerStats) (float64, bool) {
+			return fdsValue(c, func(f *info.FdStats) float64 { return f.Utilisation })
+		}),
+		podMetric{
+			name:        "container_max_open_files",
+			help:        "the lowest max open files of processes of container, +Inf if unlimited",
+			valueType:   prometheus.GaugeValue,
+			extraLabels: []string{"container", "limit"},
+			getValues: func(s *info.Stats) metricValues {
+				values := metricValues{}
+				for i := range s.Containers {
+					cont := &s.Containers[i]
+					if cont.Fds == nil {
+						continue
+					}
+					values = append(values,
+						metricValue{value: cont.Fds.SoftLimit, labels: []string{cont.Name, "soft"}},
+						metricValue{value: cont.Fds.HardLimit, labels: []string{cont.Name, "hard"}},
+					)
+				}
+				return values
+			},
+		},
+	)
+
+	c.processMetrics = append(c.processMetrics,
+		podGauge("pod_processes", "processes of all containers of pod", func(s *info.Stats) (float64, bool) {
+			return processValue(s.Processes, func(p *info.ProcessStats) uint64 { return p.Processes })
+		}),
+		podGauge("pod_threads", "threads of all processes of pod", func(s *info.Stats) (float64, bool) {
+			return processValue(s.Processes, func(p *info.ProcessStats) uint64 { return p.Threads })
+		}),
+		podGauge("pod_zombie_processes", "processes of pod exited but not reaped by their parents", func(s *info.Stats) (float64, bool) {
+			return processValue(s.Processes, func(p *info.ProcessStats) uint64 { return p.Zombies })
+		}),
+		podGauge("pod_pids_current", "pids.current of pod cgroup, tasks of all containers and the sandbox", func(s *info.Stats) (float64, bool) {
+			return pidsValue(s.Pids, false)
+		}),
+		podGauge("pod_pids_max", "pids.max of pod cgroup, +Inf if unlimited", func(s *info.Stats) (float64, bool) {
+			return pidsValue(s.Pids, true)
+		}),
+		containerMetric("container_processes", "processes of container", func(c *info.ContainerStats) (float64, bool) {
+			return processValue(c.Processes, func(p *info.ProcessStats) uint64 { return p.Processes })
+		}),
+		containerMetric("container_threads", "threads of all processes of container", func(c *info.ContainerStats) (float64, bool) {
+			return processValue(c.Processes, func(p *info.ProcessStats) uint64 { return p.Threads })
+		}),
+		containerMetric("container_zombie_processes", "processes of container exited but not reaped by their parents", func(c *info.ContainerStats) (float64, bool) {
+			return processValue(c.Processes, func(p *info.ProcessStats) uint64 { return p.Zombies })
+		}),
+		containerMetric("container_pids_current", "pids.current of container cgroup", func(c *info.ContainerStats) (float64, bool) {
+			return pidsValue(c.Pids, false)
+		}),
+		containerMetric("container_pids_max", "pids.max of container cgroup, +Inf if unlimited", func(c *info.ContainerStats) (float64, bool) {
+			return pidsValue(c.Pids, true)
+		}),
+	)
+
+	c.processMetrics = append(c.processMetrics, podMetric{
+		name:        "pod_pressure_ratio",
+		help:        "share of time tasks of pod stalled on resource in the last window, some is when part of tasks stalled and full is when all did, cgroup v2 only",
+		valueType:   prometheus.GaugeValue,
+		extraLabels: []string{"resource", "kind", "window"},
+		getValues: func(s *info.Stats) metricValues {
+			values := make(metricValues, 0, 3*len(s.Pressure))
+			for _, p := range s.Pressure {
+				values = append(values,
+					metricValue{value: p.Avg10 / 100, labels: []string{p.Resource, p.Kind, "10s"}},
+					metricValue{value: p.Avg60 / 100, labels: []string{p.Resource, p.Kind, "60s"}},
+					metricValue{value: p.Avg300 / 100, labels: []string{p.Resource, p.Kind, "300s"}},
+				)
+			}
+			return values
+		},
+	}, podMetric{
+		name:        "pod_pressure_stalled_seconds_total",
+		help:        "Cumulative time tasks of pod stalled on resource, some is when part of tasks stalled and full is when all did, cgroup v2 only",
+		valueType:   prometheus.CounterValue,
+		extraLabels: []string{"resource", "kind"},
+		getValues: func(s *info.Stats) metricValues {
+			values := make(metricValues, 0, len(s.Pressure))
+			for _, p := range s.Pressure {
+				values = append(values, metricValue{
+					value:  float64(p.Total) / 1e6,
+					labels: []string{p.Resource, p.Kind},
+				})
+			}
+			return values
+		},
+	})
+
+	c.podMetrics = append(c.podMetrics,
+		tcpInfoMetric("pod_tcp_rtt_seconds", "smoothed round trip time", func(i *network.TcpInfoStat) *network.Histogram { return &i.Rtt }),
+		tcpInfoMetric("pod_tcp_rtt_variance_seconds", "round trip time variance", func(i *network.TcpInfoStat) *network.Histogram { return &i.RttVar }),
+		tcpInfoMetric("pod_tcp_congestion_window_segments", "congestion window", func(i *network.TcpInfoStat) *network.Histogram { return &i.Cwnd }),
+		tcpInfoMetric("pod_tcp_retransmitted_segments", "total retransmitted segments", func(i *network.TcpInfoStat) *network.Histogram { return &i.Retrans }),
+		tcpInfoMetric("pod_tcp_unacked_segments", "unacknowledged segments", func(i *network.TcpInfoStat) *network.Histogram { return &i.Unacked }),
+		tcpInfoMetric("pod_tcp_lost_segments", "lost segments", func(i *network.TcpInfoStat) *network.Histogram { return &i.Lost }),
+	)
+
+	for _, field := range netstatFields {
+		c.podMetrics = append(c.podMetrics, netstatMetric(field))
+	}
+
+	return c
+}
+
+// interfaceMetric creates a counter with a series for every interface of pod.
+func interfaceMetric(name, help string, getValue func(i *network.InterfaceStat) uint64) podMetric {
+	return podMetric{
+		name:        name,
+		help:        "Cumulative count of " + help + " by pod interface",
+		valueType:   prometheus.CounterValue,
+		extraLabels: []string{"interface"},
+		getValues: func(s *info.Stats) metricValues {
+			values := make(metricValues, 0, len(s.Network.Interfaces))
+			for i := range s.Network.Interfaces {
+				iface := &s.Network.Interfaces[i]
+				values = append(values, metricValue{
+					value:  float64(getValue(iface)),
+					labels: []string{iface.Name},
+				})
+			}
+			return values
+		},
+	}
+}
+
+// podGauge creates a gauge of pod, getValue returns false if the value is
+// unknown.
+func podGauge(name, help string, getValue func(s *info.Stats) (float64, bool)) podMetric {
+	return podMetric{
+		name:      name,
+		help:      help,
+		valueType: prometheus.GaugeValue,
+		getValues: func(s *info.Stats) metricValues {
+			v, ok := getValue(s)
+			if !ok {
+				return nil
+			}
+			return metricValues{{value: v}}
+		},
+	}
+}
+
+// containerMetric creates a gauge with a series for every container of pod,
+// getValue returns false if the value of container is unknown.
+func containerMetric(name, help string, getValue func(c *info.ContainerStats) (float64, bool)) podMetric {
+	return podMetric{
+		name:        name,
+		help:        help,
+		valueType:   prometheus.GaugeValue,
+		extraLabels: []string{"container"},
+		getValues: func(s *info.Stats) metricValues {
+			values := metricValues{}
+			for i := range s.Containers {
+				cont := &s.Containers[i]
+				v, ok := getValue(cont)
+				if !ok {
+					continue
+				}
+				values = append(values, metricValue{
+					value:  v,
+					labels: []string{cont.Name},
+				})
+			}
+			return values
+		},
+	}
+}
+
+func fdsValue(c *info.ContainerStats, get func(f *info.FdStats) float64) (float64, bool) {
+	if c.Fds == nil {
+		return 0, false
+	}
+	return get(c.Fds), true
+}
+
+func processValue(p *info.ProcessStats, get func(p *info.ProcessStats) uint64) (float64, bool) {
+	if p == nil {
+		return 0, false
+	}
+	return float64(get(p)), true
+}
+
+func pidsValue(p *info.PidsCgroupStats, max bool) (float64, bool) {
+	if p == nil {
+		return 0, false
+	}
+	if max {
+		return p.Max, true
+	}
+	return float64(p.Current), true
+}
+
+// tcpInfoMetric creates a histogram of tcp_info of pod connections by local
+// listening port, connections initiated by pod are labelled network.OutboundPort.
+func tcpInfoMetric(name, help string, getHistogram func(i *network.TcpInfoStat) *network.Histogram) podMetric {
+	return podMetric{
+		name:        name,
+		help:        "Distribution of " + help + " of pod tcp connections",
+		extraLabels: []string{"listen_port"},
+		getValues: func(s *info.Stats) metricValues {
+			values := make(metricValues, 0, len(s.Network.TcpInfo))
+			for port, stat := range s.Network.TcpInfo {
+				values = append(values, metricValue{
+					histogram: getHistogram(stat),
+					labels:    []string{port},
+				})
+			}
+			return values
+		},
+	}
+}
+
+func netstatMetric(field string) podMetric {
+	return podMetric{
+		name:      "pod_netstat_" + field,
+		help:      fmt.Sprintf("%s counter from /proc/net/snmp or /proc/net/netstat of pod network namespace", field),
+		valueType: prometheus.CounterValue,
+		getValues: func(s *info.Stats) metricValues {
+			v, ok := s.Counters[field]
+			if !ok {
+				return nil
+			}
+			return metricValues{{value: v}}
 		},
 	}
 }
@@ -152,12 +736,24 @@ func (c *PrometheusCollector) Collect(ch chan<- prometheus.Metric) {
 	c.errors.Set(0)
 	c.collectPodsInfo(ch)
 	c.errors.Collect(ch)
+	for key, count := range c.infoProvider.DiscoveryErrors() {
+		ch <- prometheus.MustNewConstMetric(c.discoveryErrors, prometheus.CounterValue, float64(count), key.Namespace, key.PodName, key.Reason)
+	}
+	for key, count := range c.infoProvider.CollectTimeouts() {
+		ch <- prometheus.MustNewConstMetric(c.collectTimeouts, prometheus.CounterValue, float64(count), key.Namespace, key.PodName)
+	}
+	tcpInfoEnabled := 0.0
+	if c.infoProvider.TcpInfoEnabled() {
+		tcpInfoEnabled = 1
+	}
+	ch <- prometheus.MustNewConstMetric(c.tcpInfoEnabled, prometheus.GaugeValue, tcpInfoEnabled)
 }
 
 func defaultPodLabels(i *info.Stats) map[string]string {
 	labels := map[string]string{}
 	labels["pod"] = i.PodName
 	labels["namespace"] = i.Namespace
+	labels["host_network"] = strconv.FormatBool(i.HostNetwork)
 	return labels
 }
 
@@ -178,23 +774,53 @@ func (c *PrometheusCollector) collectPodsInfo(ch chan<- prometheus.Metric) {
 			values = append(values, lv)
 		}
 
-		for _, metric := range c.podMetrics {
+		// Network namespace of pod may not be read, e.g. it's excluded
+		// hostNetwork one.
+		if info.Network != nil {
+			collectMetrics(ch, c.podMetrics, info, info.CollectedAt, labels, values)
+		}
+		collectMetrics(ch, c.processMetrics, info, info.ProcessesCollectedAt, labels, values)
 
-			desc := metric.desc(labels)
+		for _, metric := range c.freshness {
 			for _, v := range metric.getValues(info) {
-				ch <- prometheus.MustNewConstMetric(desc, metric.valueType, v.value, append(values, v.labels...)...)
+				ch <- prometheus.MustNewConstMetric(metric.desc(labels), metric.valueType, v.value, values...)
 			}
 		}
 	}
 }
 
+// collectMetrics delivers metrics of pod timestamped with collectedAt.
+func collectMetrics(ch chan<- prometheus.Metric, metrics []podMetric, info *info.Stats, collectedAt time.Time, labels, values []string) {
+	for _, metric := range metrics {
+		desc := metric.desc(labels)
+		for _, v := range metric.getValues(info) {
+			if v.histogram != nil {
+				ch <- prometheus.NewMetricWithTimestamp(collectedAt,
+					prometheus.MustNewConstHistogram(desc, v.histogram.Count, v.histogram.Sum, v.histogram.Buckets, append(values, v.labels...)...))
+				continue
+			}
+			ch <- prometheus.NewMetricWithTimestamp(collectedAt,
+				prometheus.MustNewConstMetric(desc, metric.valueType, v.value, append(values, v.labels...)...))
+		}
+	}
+}
+
 // Describe describes all the metrics ever exported by cadvisor. It
 // implements prometheus.PrometheusCollector.
 func (c *PrometheusCollector) Describe(ch chan<- *prometheus.Desc) {
 	c.errors.Describe(ch)
+	ch <- c.discoveryErrors
+	ch <- c.collectTimeouts
+	ch <- c.tcpInfoEnabled
+	for _, m := range c.freshness {
+		ch <- m.desc([]string{})
+	}
 	for _, m := range c.podMetrics {
 		ch <- m.desc([]string{})
 	}
+	for _, m := range c.processMetrics {
+		ch <- m.desc([]string{})
+	}
 }
 
 func (m *podMetric) desc(baseLabels []string) *prometheus.Desc {
@@ -0,0 +1,76 @@
+package network
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Counters holds protocol counters of a network namespace, keyed by the name
+// nstat uses for them, e.g. TcpRetransSegs, TcpExtListenOverflows.
+type Counters map[string]float64
+
+type CountersProvider interface {
+	GetCounters(rootFs string, pid int) (Counters, error)
+}
+
+func NewCountersProvider() CountersProvider {
+	return &snmpProvider{}
+}
+
+// snmpProvider reads counters from /proc/<pid>/net/snmp and /proc/<pid>/net/netstat,
+// both of them are scoped to the network namespace of pid.
+type snmpProvider struct {
+}
+
+func (p *snmpProvider) GetCounters(rootFs string, pid int) (Counters, error) {
+	counters := Counters{}
+	for _, file := range []string{"net/snmp", "net/netstat"} {
+		countersFile := path.Join(rootFs, "proc", strconv.Itoa(pid), file)
+		if err := scanCounters(countersFile, counters); err != nil {
+			return nil, fmt.Errorf("err get counters from pid %v: %v", pid, err)
+		}
+	}
+	return counters, nil
+}
+
+// scanCounters parses files made of line pairs like:
+//
+//	Tcp: RtoAlgorithm RtoMin RtoMax MaxConn ActiveOpens ...
+//	Tcp: 1 200 120000 -1 4375 ...
+func scanCounters(countersFile string, counters Counters) error {
+	f, err := os.Open(countersFile)
+	if err != nil {
+		return fmt.Errorf("failure opening %s: %v", countersFile, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Split(bufio.ScanLines)
+
+	for scanner.Scan() {
+		names := strings.Fields(scanner.Text())
+		if !scanner.Scan() {
+			return fmt.Errorf("missing values for %v", names)
+		}
+		values := strings.Fields(scanner.Text())
+
+		if len(names) == 0 || len(names) != len(values) || names[0] != values[0] {
+			return fmt.Errorf("invalid counters lines: %v, %v", names, values)
+		}
+
+		prefix := strings.TrimSuffix(names[0], ":")
+		for i := 1; i < len(names); i++ {
+			v, err := strconv.ParseFloat(values[i], 64)
+			if err != nil {
+				return fmt.Errorf("invalid value %v of %v%v: %v", values[i], prefix, names[i], err)
+			}
+			counters[prefix+names[i]] = v
+		}
+	}
+
+	return scanner.Err()
+}
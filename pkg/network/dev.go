@@ -0,0 +1,102 @@
+package network
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+type InterfaceStat struct {
+	// Name of the interface, e.g. eth0
+	Name string
+
+	RxBytes   uint64
+	RxPackets uint64
+	RxErrors  uint64
+	RxDropped uint64
+	RxFifo    uint64
+	RxFrame   uint64
+
+	TxBytes   uint64
+	TxPackets uint64
+	TxErrors  uint64
+	TxDropped uint64
+	TxFifo    uint64
+}
+
+func interfaceStatsFromProc(rootFs string, pid int, includeLoopback bool) ([]InterfaceStat, error) {
+	devFile := path.Join(rootFs, "proc", strconv.Itoa(pid), "net/dev")
+
+	ifStats, err := scanInterfaceStats(devFile, includeLoopback)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read interface stats: %v", err)
+	}
+
+	return ifStats, nil
+}
+
+func scanInterfaceStats(devFile string, includeLoopback bool) ([]InterfaceStat, error) {
+	f, err := os.Open(devFile)
+	if err != nil {
+		return nil, fmt.Errorf("failure opening %s: %v", devFile, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Split(bufio.ScanLines)
+
+	// Discard two header lines
+	for i := 0; i < 2; i++ {
+		if b := scanner.Scan(); !b {
+			return nil, scanner.Err()
+		}
+	}
+
+	stats := []InterfaceStat{}
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Format: face: bytes packets errs drop fifo frame compressed multicast bytes packets errs drop fifo colls carrier compressed
+		i := strings.Index(line, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid interface stats line: %v", line)
+		}
+		name := strings.TrimSpace(line[:i])
+		if name == "lo" && !includeLoopback {
+			continue
+		}
+
+		fields := strings.Fields(line[i+1:])
+		if len(fields) < 16 {
+			return nil, fmt.Errorf("invalid interface stats line: %v", line)
+		}
+		values := make([]uint64, len(fields))
+		for j, field := range fields {
+			v, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid interface stats line %v: %v", line, err)
+			}
+			values[j] = v
+		}
+
+		stats = append(stats, InterfaceStat{
+			Name:      name,
+			RxBytes:   values[0],
+			RxPackets: values[1],
+			RxErrors:  values[2],
+			RxDropped: values[3],
+			RxFifo:    values[4],
+			RxFrame:   values[5],
+			TxBytes:   values[8],
+			TxPackets: values[9],
+			TxErrors:  values[10],
+			TxDropped: values[11],
+			TxFifo:    values[12],
+		})
+	}
+
+	return stats, scanner.Err()
+}
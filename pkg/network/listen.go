@@ -0,0 +1,62 @@
+package network
+
+import (
+	"sort"
+)
+
+// ListenPort is a local port sockets of pod listen on, Proto is "tcp" or "udp".
+type ListenPort struct {
+	Proto string
+	Port  uint16
+}
+
+// Default net.ipv4.ip_local_port_range, kernel picks local ports of client
+// sockets bound implicitly from it.
+const (
+	ephemeralPortMin = 32768
+	ephemeralPortMax = 60999
+)
+
+// IsEphemeralPort tells whether port is in the default ephemeral port range.
+// Unconnected udp sockets on them are mostly clients calling sendto, e.g. dns
+// and statsd clients, so they aren't told from udp servers.
+func IsEphemeralPort(port uint16) bool {
+	return port >= ephemeralPortMin && port <= ephemeralPortMax
+}
+
+// listenCollector gathers ports of tcp listening sockets and udp sockets
+// bound outside ephemeral ports, ports of ipv4 and ipv6 sockets are merged.
+type listenCollector struct {
+	ports map[ListenPort]bool
+}
+
+func newListenCollector() *listenCollector {
+	return &listenCollector{ports: map[ListenPort]bool{}}
+}
+
+func (c *listenCollector) add(s *tcpSocket) {
+	if s.state == tcpListen {
+		c.ports[ListenPort{Proto: "tcp", Port: s.localPort}] = true
+	}
+}
+
+func (c *listenCollector) addUdp(port uint16) {
+	if port != 0 && !IsEphemeralPort(port) {
+		c.ports[ListenPort{Proto: "udp", Port: port}] = true
+	}
+}
+
+// list returns ports sorted by proto and port.
+func (c *listenCollector) list() []ListenPort {
+	ports := make([]ListenPort, 0, len(c.ports))
+	for p := range c.ports {
+		ports = append(ports, p)
+	}
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].Proto != ports[j].Proto {
+			return ports[i].Proto < ports[j].Proto
+		}
+		return ports[i].Port < ports[j].Port
+	})
+	return ports
+}
@@ -0,0 +1,244 @@
+//go:build linux
+// +build linux
+
+package network
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"github.com/caicloud/nirvana/log"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// sockDiagByFamily is SOCK_DIAG_BY_FAMILY from include/uapi/linux/sock_diag.h.
+	sockDiagByFamily = 20
+	// tcpAllStates is the state filter selecting tcpEstablished to tcpClosing.
+	tcpAllStates = (1<<(tcpClosing+1) - 1) &^ 1
+	// inetDiagInfo is INET_DIAG_INFO, the attribute carrying struct tcp_info.
+	inetDiagInfo = 2
+)
+
+// Offsets of fields in struct tcp_info from include/uapi/linux/tcp.h.
+const (
+	tcpInfoUnackedOffset      = 24
+	tcpInfoLostOffset         = 32
+	tcpInfoRttOffset          = 68
+	tcpInfoRttVarOffset       = 72
+	tcpInfoSndCwndOffset      = 80
+	tcpInfoTotalRetransOffset = 100
+	tcpInfoMinLen             = tcpInfoTotalRetransOffset + 4
+)
+
+// inetDiagSockID is struct inet_diag_sockid, ports are in network byte order.
+type inetDiagSockID struct {
+	SPort  [2]byte
+	DPort  [2]byte
+	Src    [16]byte
+	Dst    [16]byte
+	If     uint32
+	Cookie [2]uint32
+}
+
+// inetDiagReqV2 is struct inet_diag_req_v2.
+type inetDiagReqV2 struct {
+	Family   uint8
+	Protocol uint8
+	Ext      uint8
+	Pad      uint8
+	States   uint32
+	ID       inetDiagSockID
+}
+
+// inetDiagMsg is struct inet_diag_msg.
+type inetDiagMsg struct {
+	Family  uint8
+	State   uint8
+	Timer   uint8
+	Retrans uint8
+	ID      inetDiagSockID
+	Expires uint32
+	RQueue  uint32
+	WQueue  uint32
+	UID     uint32
+	Inode   uint32
+}
+
+// netlinkTcpWalker walks tcp sockets with NETLINK_SOCK_DIAG, which is much
+// cheaper than formatting and parsing /proc/<pid>/net/tcp for huge tables.
+type netlinkTcpWalker struct {
+	proc procTcpWalker
+	// info requests tcp_info of every socket.
+	info bool
+	// fallback is set to 1 once entering network namespaces is not permitted.
+	fallback int32
+}
+
+func newNetlinkTcpWalker(withInfo bool) tcpWalker {
+	return &netlinkTcpWalker{info: withInfo}
+}
+
+func (w *netlinkTcpWalker) withInfo() bool {
+	return w.info && atomic.LoadInt32(&w.fallback) == 0
+}
+
+func (w *netlinkTcpWalker) walkTcp(rootFs string, pid int, ipv6 bool, fn func(s *tcpSocket)) error {
+	if atomic.LoadInt32(&w.fallback) == 0 {
+		fd, err := netlinkSocketIn(rootFs, pid)
+		if err == nil {
+			defer unix.Close(fd)
+			return dumpTcpSockets(fd, ipv6, w.info, fn)
+		}
+		if !os.IsPermission(err) {
+			return fmt.Errorf("err open netlink socket in netns of pid %v: %v", pid, err)
+		}
+		if atomic.CompareAndSwapInt32(&w.fallback, 0, 1) {
+			if w.info {
+				log.Warningf("Netlink backend lacks privileges, fall back to proc and stop collecting tcp_info: %v", err)
+			} else {
+				log.Warningf("Netlink backend lacks privileges, fall back to proc: %v", err)
+			}
+		}
+	}
+	return w.proc.walkTcp(rootFs, pid, ipv6, fn)
+}
+
+// netlinkSocketIn creates a NETLINK_SOCK_DIAG socket in the network namespace
+// of pid. A socket stays in the namespace it is created in, so only creation
+// happens inside the namespace.
+func netlinkSocketIn(rootFs string, pid int) (int, error) {
+	fd := -1
+	err := inNetns(rootFs, pid, func() error {
+		var err error
+		fd, err = unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_INET_DIAG)
+		if err != nil {
+			return os.NewSyscallError("socket", err)
+		}
+		return nil
+	})
+	if err != nil && fd >= 0 {
+		unix.Close(fd)
+		fd = -1
+	}
+	return fd, err
+}
+
+// dumpTcpSockets asks kernel for all tcp sockets of the given family.
+func dumpTcpSockets(fd int, ipv6 bool, withInfo bool, fn func(s *tcpSocket)) error {
+	req := struct {
+		hdr unix.NlMsghdr
+		req inetDiagReqV2
+	}{
+		hdr: unix.NlMsghdr{
+			Type:  sockDiagByFamily,
+			Flags: unix.NLM_F_REQUEST | unix.NLM_F_DUMP,
+			Seq:   1,
+		},
+		req: inetDiagReqV2{
+			Family:   unix.AF_INET,
+			Protocol: unix.IPPROTO_TCP,
+			States:   tcpAllStates,
+		},
+	}
+	if ipv6 {
+		req.req.Family = unix.AF_INET6
+	}
+	if withInfo {
+		req.req.Ext |= 1 << (inetDiagInfo - 1)
+	}
+	req.hdr.Len = uint32(unsafe.Sizeof(req))
+
+	reqBytes := (*[unsafe.Sizeof(req)]byte)(unsafe.Pointer(&req))[:]
+	if err := unix.Sendto(fd, reqBytes, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return os.NewSyscallError("sendto", err)
+	}
+
+	buf := make([]byte, 64*1024)
+	var sock tcpSocket
+	var info tcpInfo
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return os.NewSyscallError("recvfrom", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+
+		for _, msg := range msgs {
+			switch msg.Header.Type {
+			case unix.NLMSG_DONE:
+				return nil
+			case unix.NLMSG_ERROR:
+				if len(msg.Data) < 4 {
+					return fmt.Errorf("truncated netlink error message")
+				}
+				errno := -*(*int32)(unsafe.Pointer(&msg.Data[0]))
+				return os.NewSyscallError("sock_diag", syscall.Errno(errno))
+			}
+
+			if len(msg.Data) < int(unsafe.Sizeof(inetDiagMsg{})) {
+				return fmt.Errorf("truncated inet_diag message")
+			}
+			diag := (*inetDiagMsg)(unsafe.Pointer(&msg.Data[0]))
+
+			sock = tcpSocket{
+				state:      diag.State,
+				localPort:  uint16(diag.ID.SPort[0])<<8 | uint16(diag.ID.SPort[1]),
+				remotePort: uint16(diag.ID.DPort[0])<<8 | uint16(diag.ID.DPort[1]),
+				rxQueue:    diag.RQueue,
+				txQueue:    diag.WQueue,
+				inode:      uint64(diag.Inode),
+			}
+			if diag.Family == unix.AF_INET {
+				copy(sock.remoteIP[:], net.IPv4(diag.ID.Dst[0], diag.ID.Dst[1], diag.ID.Dst[2], diag.ID.Dst[3]))
+			} else {
+				sock.remoteIP = diag.ID.Dst
+			}
+			if withInfo && parseTcpInfo(msg.Data[unsafe.Sizeof(inetDiagMsg{}):], &info) {
+				sock.info = &info
+			}
+			fn(&sock)
+		}
+	}
+}
+
+// parseTcpInfo looks for INET_DIAG_INFO in attributes following inet_diag_msg.
+func parseTcpInfo(attrs []byte, info *tcpInfo) bool {
+	for len(attrs) >= unix.SizeofRtAttr {
+		attr := (*unix.RtAttr)(unsafe.Pointer(&attrs[0]))
+		if int(attr.Len) < unix.SizeofRtAttr || int(attr.Len) > len(attrs) {
+			return false
+		}
+		if attr.Type == inetDiagInfo {
+			data := attrs[unix.SizeofRtAttr:attr.Len]
+			if len(data) < tcpInfoMinLen {
+				return false
+			}
+			u32 := func(offset int) uint32 {
+				return *(*uint32)(unsafe.Pointer(&data[offset]))
+			}
+			*info = tcpInfo{
+				rtt:          u32(tcpInfoRttOffset),
+				rttVar:       u32(tcpInfoRttVarOffset),
+				cwnd:         u32(tcpInfoSndCwndOffset),
+				totalRetrans: u32(tcpInfoTotalRetransOffset),
+				unacked:      u32(tcpInfoUnackedOffset),
+				lost:         u32(tcpInfoLostOffset),
+			}
+			return true
+		}
+		next := (int(attr.Len) + unix.NLMSG_ALIGNTO - 1) &^ (unix.NLMSG_ALIGNTO - 1)
+		if next > len(attrs) {
+			return false
+		}
+		attrs = attrs[next:]
+	}
+	return false
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package network
+
+import (
+	"github.com/caicloud/nirvana/log"
+)
+
+// newNetlinkTcpWalker falls back to proc, NETLINK_SOCK_DIAG only exists on linux.
+func newNetlinkTcpWalker(withInfo bool) tcpWalker {
+	log.Warningf("Netlink backend is not supported on this platform, fall back to proc")
+	return procTcpWalker{}
+}
+
+// readSomaxconn reports somaxconn unknown, network namespaces only exist on linux.
+func readSomaxconn(rootFs string, pid int) (uint64, error) {
+	return 0, nil
+}
@@ -0,0 +1,83 @@
+//go:build linux
+// +build linux
+
+package network
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/caicloud/nirvana/log"
+	"golang.org/x/sys/unix"
+)
+
+// inNetns calls fn in the network namespace of pid, it requires CAP_SYS_ADMIN.
+func inNetns(rootFs string, pid int, fn func() error) error {
+	ch := make(chan error, 1)
+
+	// Switch namespace in a dedicated goroutine, if the thread can't be
+	// switched back it stays locked and is terminated when goroutine exits.
+	go func() {
+		runtime.LockOSThread()
+
+		origNs, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+		if err != nil {
+			runtime.UnlockOSThread()
+			ch <- err
+			return
+		}
+		defer origNs.Close()
+
+		targetNs, err := os.Open(path.Join(rootFs, "proc", strconv.Itoa(pid), "ns/net"))
+		if err != nil {
+			runtime.UnlockOSThread()
+			ch <- err
+			return
+		}
+		defer targetNs.Close()
+
+		if err := unix.Setns(int(targetNs.Fd()), unix.CLONE_NEWNET); err != nil {
+			runtime.UnlockOSThread()
+			ch <- os.NewSyscallError("setns", err)
+			return
+		}
+
+		err = fn()
+
+		if restoreErr := unix.Setns(int(origNs.Fd()), unix.CLONE_NEWNET); restoreErr != nil {
+			log.Errorf("Err restore network namespace of thread: %v", restoreErr)
+			ch <- fmt.Errorf("err restore network namespace: %v", restoreErr)
+			return
+		}
+		runtime.UnlockOSThread()
+
+		ch <- err
+	}()
+
+	return <-ch
+}
+
+// readSomaxconn reads net.core.somaxconn of the network namespace of pid.
+// Entries under /proc/sys/net belong to the namespace of the reading thread,
+// so the file is opened inside the namespace.
+func readSomaxconn(rootFs string, pid int) (uint64, error) {
+	var data []byte
+	err := inNetns(rootFs, pid, func() error {
+		var err error
+		data, err = ioutil.ReadFile("/proc/sys/net/core/somaxconn")
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid somaxconn %q: %v", data, err)
+	}
+	return v, nil
+}
@@ -3,117 +3,312 @@ package network
 import (
 	"bufio"
 	"fmt"
-	"io/ioutil"
+	"net"
+	"os"
 	"path"
 	"strconv"
 	"strings"
 )
 
 type StatsProvider interface {
-	GetStats(rootFs string, pid int) (*Stats, error)
+	// GetStats reads stats of network namespace of pid, tcp connections of
+	// sockets in owners are counted by owner, nil owners disables it.
+	GetStats(rootFs string, pid int, owners SocketOwners) (*Stats, error)
+	// TcpInfoEnabled tells whether distributions of tcp_info are collected,
+	// they stop once netlink backend falls back to proc.
+	TcpInfoEnabled() bool
 }
 
-func NewStatsProvider() StatsProvider {
-	return &defaultProvider{}
+const (
+	// BackendProc counts tcp sockets by scanning /proc/<pid>/net/tcp{,6}.
+	BackendProc = "proc"
+	// BackendNetlink counts tcp sockets through NETLINK_SOCK_DIAG inside the
+	// network namespace of pid, it falls back to proc without privileges.
+	BackendNetlink = "netlink"
+)
+
+// Options configures what a StatsProvider collects.
+type Options struct {
+	// Backend used to walk tcp sockets, BackendProc by default.
+	Backend string
+	// IncludeLoopback reports counters of loopback interfaces too.
+	IncludeLoopback bool
+	// TcpInfo collects distributions of kernel tcp_info, BackendNetlink only.
+	TcpInfo bool
+	// RemoteTopN aggregates tcp connections by remote endpoint and keeps the
+	// top N endpoints with the most connections, 0 disables it.
+	RemoteTopN int
+	// Outbound aggregates tcp connections by remote endpoint without a cap,
+	// e.g. for connection graph.
+	Outbound bool
+}
+
+func NewStatsProvider(opts Options) (StatsProvider, error) {
+	p := &defaultProvider{opts: opts, somaxconn: newSomaxconnCache()}
+	switch opts.Backend {
+	case "", BackendProc:
+		p.tcp = procTcpWalker{}
+	case BackendNetlink:
+		p.tcp = newNetlinkTcpWalker(opts.TcpInfo)
+	default:
+		return nil, fmt.Errorf("unknown network backend %q", opts.Backend)
+	}
+
+	if opts.TcpInfo && opts.Backend != BackendNetlink {
+		return nil, fmt.Errorf("tcp info requires %s backend", BackendNetlink)
+	}
+	return p, nil
+}
+
+// tcpSocket describes a tcp socket walked from proc or netlink.
+type tcpSocket struct {
+	state     uint8
+	localPort uint16
+	// remoteIP is in 16-byte form for both ipv4 and ipv6.
+	remoteIP   [net.IPv6len]byte
+	remotePort uint16
+	// rxQueue and txQueue are unread and unsent bytes of connections. Of
+	// listening sockets, rxQueue is the accept backlog and txQueue its limit,
+	// which is only available from netlink.
+	rxQueue uint32
+	txQueue uint32
+	// info is only available from netlink.
+	info *tcpInfo
+	// inode of socket, 0 for sockets in TIME_WAIT which have no file.
+	inode uint64
+}
+
+// tcpWalker calls fn for every tcp socket in the network namespace of pid.
+type tcpWalker interface {
+	walkTcp(rootFs string, pid int, ipv6 bool, fn func(s *tcpSocket)) error
+	// withInfo tells whether sockets walked carry tcp_info.
+	withInfo() bool
 }
 
 type defaultProvider struct {
+	opts      Options
+	tcp       tcpWalker
+	somaxconn *somaxconnCache
+}
+
+func (p *defaultProvider) TcpInfoEnabled() bool {
+	return p.opts.TcpInfo && p.tcp.withInfo()
 }
 
-func (p *defaultProvider) GetStats(rootFs string, pid int) (*Stats, error) {
-	tcpStat, err := tcpStatsFromProc(rootFs, pid, "net/tcp")
+func (p *defaultProvider) GetStats(rootFs string, pid int, owners SocketOwners) (*Stats, error) {
+	listens := newListenCollector()
+	queues := newQueueCollector()
+	visitors := []func(s *tcpSocket){listens.add, queues.add}
+	var infos *tcpInfoCollector
+	if p.opts.TcpInfo {
+		infos = newTcpInfoCollector()
+		visitors = append(visitors, infos.add)
+	}
+	var remotes *remoteCollector
+	if p.opts.RemoteTopN > 0 || p.opts.Outbound {
+		remotes = newRemoteCollector()
+		visitors = append(visitors, remotes.add)
+	}
+	var ownerStats *ownerCollector
+	if owners != nil {
+		ownerStats = newOwnerCollector(owners)
+		visitors = append(visitors, ownerStats.add)
+	}
+
+	tcpStat, err := p.tcpStats(rootFs, pid, false, visitors)
 	if err != nil {
 		return nil, fmt.Errorf("err get tcp stats from pid %v: %v", pid, err)
 	}
 
-	tcp6Stat, err := tcpStatsFromProc(rootFs, pid, "net/tcp6")
+	tcp6Stat, err := p.tcpStats(rootFs, pid, true, visitors)
 	if err != nil {
 		return nil, fmt.Errorf("err get tcp stats from pid %v: %v", pid, err)
 	}
 
-	return &Stats{
-		Tcp:  tcpStat,
-		Tcp6: tcp6Stat,
-	}, nil
-}
+	udpStat, err := udpStatsFromProc(rootFs, pid, "net/udp", listens.addUdp)
+	if err != nil {
+		return nil, fmt.Errorf("err get udp stats from pid %v: %v", pid, err)
+	}
 
-func tcpStatsFromProc(rootFs string, pid int, file string) (TcpStat, error) {
-	tcpStatsFile := path.Join(rootFs, "proc", strconv.Itoa(pid), file)
+	udp6Stat, err := udpStatsFromProc(rootFs, pid, "net/udp6", listens.addUdp)
+	if err != nil {
+		return nil, fmt.Errorf("err get udp stats from pid %v: %v", pid, err)
+	}
 
-	tcpStats, err := scanTcpStats(tcpStatsFile)
+	ifStats, err := interfaceStatsFromProc(rootFs, pid, p.opts.IncludeLoopback)
 	if err != nil {
-		return tcpStats, fmt.Errorf("couldn't read tcp stats: %v", err)
+		return nil, fmt.Errorf("err get interface stats from pid %v: %v", pid, err)
 	}
 
-	return tcpStats, nil
+	stats := &Stats{
+		Tcp:        tcpStat,
+		Tcp6:       tcp6Stat,
+		Udp:        udpStat,
+		Udp6:       udp6Stat,
+		Interfaces: ifStats,
+		Listening:  listens.list(),
+		Backlogs:   queues.backlogs(),
+		RecvQueued: queues.recvQueued,
+		SendQueued: queues.sendQueued,
+		Somaxconn:  p.somaxconn.get(rootFs, pid),
+	}
+	if infos != nil {
+		stats.TcpInfo = infos.stats()
+	}
+	if p.opts.RemoteTopN > 0 {
+		stats.Remotes = remotes.top(p.opts.RemoteTopN)
+	}
+	if p.opts.Outbound {
+		stats.Outbound = remotes.all()
+	}
+	if ownerStats != nil {
+		stats.Owners = ownerStats.list()
+	}
+	return stats, nil
 }
 
-func scanTcpStats(tcpStatsFile string) (TcpStat, error) {
+// tcpStats counts tcp sockets by state, visitors are called for every socket.
+func (p *defaultProvider) tcpStats(rootFs string, pid int, ipv6 bool, visitors []func(s *tcpSocket)) (TcpStat, error) {
 	var stats TcpStat
+	err := p.tcp.walkTcp(rootFs, pid, ipv6, func(s *tcpSocket) {
+		stats.count(s.state)
+		for _, visit := range visitors {
+			visit(s)
+		}
+	})
+	return stats, err
+}
 
-	data, err := ioutil.ReadFile(tcpStatsFile)
-	if err != nil {
-		return stats, fmt.Errorf("failure opening %s: %v", tcpStatsFile, err)
+type procTcpWalker struct{}
+
+func (procTcpWalker) withInfo() bool {
+	return false
+}
+
+func (procTcpWalker) walkTcp(rootFs string, pid int, ipv6 bool, fn func(s *tcpSocket)) error {
+	file := "net/tcp"
+	if ipv6 {
+		file = "net/tcp6"
 	}
+	tcpStatsFile := path.Join(rootFs, "proc", strconv.Itoa(pid), file)
 
-	tcpStateMap := map[string]uint64{
-		"01": 0, //ESTABLISHED
-		"02": 0, //SYN_SENT
-		"03": 0, //SYN_RECV
-		"04": 0, //FIN_WAIT1
-		"05": 0, //FIN_WAIT2
-		"06": 0, //TIME_WAIT
-		"07": 0, //CLOSE
-		"08": 0, //CLOSE_WAIT
-		"09": 0, //LAST_ACK
-		"0A": 0, //LISTEN
-		"0B": 0, //CLOSING
+	if err := scanTcpSockets(tcpStatsFile, fn); err != nil {
+		return fmt.Errorf("couldn't read tcp stats: %v", err)
 	}
 
-	reader := strings.NewReader(string(data))
-	scanner := bufio.NewScanner(reader)
+	return nil
+}
+
+// scanTcpSockets reads tcpStatsFile line by line, so tables of huge
+// size are never held in memory.
+func scanTcpSockets(tcpStatsFile string, fn func(s *tcpSocket)) error {
+	f, err := os.Open(tcpStatsFile)
+	if err != nil {
+		return fmt.Errorf("failure opening %s: %v", tcpStatsFile, err)
+	}
+	defer f.Close()
 
+	scanner := bufio.NewScanner(f)
 	scanner.Split(bufio.ScanLines)
 
 	// Discard header line
 	if b := scanner.Scan(); !b {
-		return stats, scanner.Err()
+		return scanner.Err()
 	}
 
+	var sock tcpSocket
 	for scanner.Scan() {
 		line := scanner.Text()
 
-		state := strings.Fields(line)
+		fields := strings.Fields(line)
 		// TCP state is the 4th field.
-		// Format: sl local_address rem_address st tx_queue rx_queue tr tm->when retrnsmt  uid timeout inode
-		tcpState := state[3]
-		_, ok := tcpStateMap[tcpState]
-		if !ok {
-			return stats, fmt.Errorf("invalid TCP stats line: %v", line)
+		// Format: sl local_address rem_address st tx_queue:rx_queue tr tm->when retrnsmt  uid timeout inode
+		if len(fields) < 5 {
+			return fmt.Errorf("invalid TCP stats line: %v", line)
+		}
+		state, err := strconv.ParseUint(fields[3], 16, 8)
+		if err != nil || state < tcpEstablished || state > tcpClosing {
+			return fmt.Errorf("invalid TCP stats line: %v", line)
+		}
+		localPort, err := parseProcPort(fields[1])
+		if err != nil {
+			return fmt.Errorf("invalid TCP stats line %v: %v", line, err)
 		}
-		tcpStateMap[tcpState]++
-	}
 
-	stats = TcpStat{
-		Established: tcpStateMap["01"],
-		SynSent:     tcpStateMap["02"],
-		SynRecv:     tcpStateMap["03"],
-		FinWait1:    tcpStateMap["04"],
-		FinWait2:    tcpStateMap["05"],
-		TimeWait:    tcpStateMap["06"],
-		Close:       tcpStateMap["07"],
-		CloseWait:   tcpStateMap["08"],
-		LastAck:     tcpStateMap["09"],
-		Listen:      tcpStateMap["0A"],
-		Closing:     tcpStateMap["0B"],
+		sock = tcpSocket{
+			state:     uint8(state),
+			localPort: localPort,
+		}
+		sock.remotePort, err = parseProcAddr(fields[2], &sock.remoteIP)
+		if err != nil {
+			return fmt.Errorf("invalid TCP stats line %v: %v", line, err)
+		}
+		sock.txQueue, sock.rxQueue, err = parseProcQueues(fields[4])
+		if err != nil {
+			return fmt.Errorf("invalid TCP stats line %v: %v", line, err)
+		}
+		if sock.state == tcpListen {
+			// tx_queue of listening sockets is always 0 in proc.
+			sock.txQueue = 0
+		}
+		if len(fields) > 9 {
+			sock.inode, err = strconv.ParseUint(fields[9], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid TCP stats line %v: %v", line, err)
+			}
+		}
+		fn(&sock)
 	}
 
-	return stats, nil
+	return scanner.Err()
+}
+
+// parseProcPort parses port of address in proc tables, e.g. 0100007F:0050.
+func parseProcPort(addr string) (uint16, error) {
+	i := strings.LastIndex(addr, ":")
+	if i < 0 {
+		return 0, fmt.Errorf("invalid address %v", addr)
+	}
+	port, err := strconv.ParseUint(addr[i+1:], 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address %v: %v", addr, err)
+	}
+	return uint16(port), nil
 }
 
 type Stats struct {
 	Tcp  TcpStat
 	Tcp6 TcpStat
+	Udp  UdpStat
+	Udp6 UdpStat
+
+	Interfaces []InterfaceStat
+
+	// Listening are ports of tcp listening sockets and unconnected udp sockets
+	// not on ephemeral ports.
+	Listening []ListenPort
+
+	// TcpInfo is keyed by local listening port or OutboundPort.
+	TcpInfo map[string]*TcpInfoStat
+
+	// Remotes are the remote endpoints pod has most connections with.
+	Remotes []RemoteStat
+	// Outbound are all remote endpoints pod has connections with, nil unless
+	// enabled.
+	Outbound []RemoteStat
+
+	// Backlogs are accept queues of tcp listening ports.
+	Backlogs []ListenBacklog
+	// RecvQueued is bytes received but not read by all tcp connections.
+	RecvQueued uint64
+	// SendQueued is bytes sent but not acknowledged of all tcp connections.
+	SendQueued uint64
+	// Somaxconn is net.core.somaxconn of the network namespace, 0 if unknown.
+	Somaxconn uint64
+
+	// Owners are tcp connections by owner of sockets, nil if sockets are
+	// not attributed.
+	Owners map[SocketOwner]TcpStat
 }
 
 type TcpStat struct {
@@ -140,3 +335,89 @@ type TcpStat struct {
 	// Count of TCP connections in state "Closing"
 	Closing uint64
 }
+
+// TCP states as defined in include/net/tcp_states.h.
+const (
+	tcpEstablished = 0x01
+	tcpSynSent     = 0x02
+	tcpSynRecv     = 0x03
+	tcpFinWait1    = 0x04
+	tcpFinWait2    = 0x05
+	tcpTimeWait    = 0x06
+	tcpClose       = 0x07
+	tcpCloseWait   = 0x08
+	tcpLastAck     = 0x09
+	tcpListen      = 0x0A
+	tcpClosing     = 0x0B
+)
+
+// TcpStateCount is the count of connections in a state.
+type TcpStateCount struct {
+	// State is the name of state in lower case, e.g. established, closewait
+	State string
+	Count uint64
+}
+
+// States lists connection counts of all states.
+func (s *TcpStat) States() []TcpStateCount {
+	return []TcpStateCount{
+		{"established", s.Established},
+		{"synsent", s.SynSent},
+		{"synrecv", s.SynRecv},
+		{"finwait1", s.FinWait1},
+		{"finwait2", s.FinWait2},
+		{"timewait", s.TimeWait},
+		{"close", s.Close},
+		{"closewait", s.CloseWait},
+		{"lastack", s.LastAck},
+		{"listen", s.Listen},
+		{"closing", s.Closing},
+	}
+}
+
+// Add adds connection counts of other to s.
+func (s *TcpStat) Add(other *TcpStat) {
+	s.Established += other.Established
+	s.SynSent += other.SynSent
+	s.SynRecv += other.SynRecv
+	s.FinWait1 += other.FinWait1
+	s.FinWait2 += other.FinWait2
+	s.TimeWait += other.TimeWait
+	s.Close += other.Close
+	s.CloseWait += other.CloseWait
+	s.LastAck += other.LastAck
+	s.Listen += other.Listen
+	s.Closing += other.Closing
+}
+
+func (s *TcpStat) total() uint64 {
+	return s.Established + s.SynSent + s.SynRecv + s.FinWait1 + s.FinWait2 + s.TimeWait +
+		s.Close + s.CloseWait + s.LastAck + s.Listen + s.Closing
+}
+
+func (s *TcpStat) count(state uint8) {
+	switch state {
+	case tcpEstablished:
+		s.Established++
+	case tcpSynSent:
+		s.SynSent++
+	case tcpSynRecv:
+		s.SynRecv++
+	case tcpFinWait1:
+		s.FinWait1++
+	case tcpFinWait2:
+		s.FinWait2++
+	case tcpTimeWait:
+		s.TimeWait++
+	case tcpClose:
+		s.Close++
+	case tcpCloseWait:
+		s.CloseWait++
+	case tcpLastAck:
+		s.LastAck++
+	case tcpListen:
+		s.Listen++
+	case tcpClosing:
+		s.Closing++
+	}
+}
@@ -0,0 +1,323 @@
+package network
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func writeProcFile(t *testing.T, content string) string {
+	tmpDir, err := ioutil.TempDir("", "kube-extra-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := path.Join(tmpDir, "proc-file")
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestScanUdpStats(t *testing.T) {
+	content := `   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  253: 00000000:0044 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 17352 2 0000000000000000 0
+  318: 0100007F:0085 00000000:0000 07 00000000:00000000 00:00000000 00000000   101        0 16102 2 0000000000000000 3
+  571: 0A00020F:9C41 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 17353 2 0000000000000000 0
+ 1025: 0A00020F:A6C4 08080808:0035 01 00000000:00000000 00:00000000 00000000     0        0 40021 2 0000000000000000 4
+`
+	file := writeProcFile(t, content)
+	defer os.RemoveAll(path.Dir(file))
+
+	listens := newListenCollector()
+	stats, err := scanUdpStats(file, listens.addUdp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := UdpStat{
+		Bound:     3,
+		Connected: 1,
+		Drops:     7,
+	}
+	if stats != expect {
+		t.Errorf("expect %+v, got %+v", expect, stats)
+	}
+	// Client socket on ephemeral port 40001 is not listening.
+	expectPorts := []ListenPort{{Proto: "udp", Port: 68}, {Proto: "udp", Port: 133}}
+	if ports := listens.list(); !reflect.DeepEqual(expectPorts, ports) {
+		t.Errorf("expect %+v, got %+v", expectPorts, ports)
+	}
+}
+
+func TestScanCounters(t *testing.T) {
+	content := `Tcp: RtoAlgorithm RtoMin RtoMax MaxConn ActiveOpens PassiveOpens AttemptFails RetransSegs
+Tcp: 1 200 120000 -1 4375 120 8 97
+Udp: InDatagrams NoPorts InErrors RcvbufErrors
+Udp: 1200 4 2 1
+`
+	file := writeProcFile(t, content)
+	defer os.RemoveAll(path.Dir(file))
+
+	counters := Counters{}
+	if err := scanCounters(file, counters); err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]float64{
+		"TcpMaxConn":      -1,
+		"TcpActiveOpens":  4375,
+		"TcpAttemptFails": 8,
+		"TcpRetransSegs":  97,
+		"UdpRcvbufErrors": 1,
+	}
+	for name, v := range expect {
+		if counters[name] != v {
+			t.Errorf("%s: expect %v, got %v", name, v, counters[name])
+		}
+	}
+
+	broken := writeProcFile(t, "TcpExt: SyncookiesSent\n")
+	defer os.RemoveAll(path.Dir(broken))
+	if err := scanCounters(broken, Counters{}); err == nil {
+		t.Error("expect error for missing values line")
+	}
+}
+
+func TestScanInterfaceStats(t *testing.T) {
+	content := `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:    6752      80    0    0    0     0          0         0     6752      80    0    0    0     0       0          0
+  eth0: 1826712    2513    1    2    3     4          0         0   309158    2306    5    6    7     0       0          0
+`
+	file := writeProcFile(t, content)
+	defer os.RemoveAll(path.Dir(file))
+
+	stats, err := scanInterfaceStats(file, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []InterfaceStat{
+		{
+			Name:      "eth0",
+			RxBytes:   1826712,
+			RxPackets: 2513,
+			RxErrors:  1,
+			RxDropped: 2,
+			RxFifo:    3,
+			RxFrame:   4,
+			TxBytes:   309158,
+			TxPackets: 2306,
+			TxErrors:  5,
+			TxDropped: 6,
+			TxFifo:    7,
+		},
+	}
+	if !reflect.DeepEqual(expect, stats) {
+		t.Errorf("expect %+v, got %+v", expect, stats)
+	}
+
+	stats, err = scanInterfaceStats(file, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 || stats[0].Name != "lo" {
+		t.Errorf("expect loopback included, got %+v", stats)
+	}
+}
+
+func TestNetlinkStatsProvider(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	procProvider, err := NewStatsProvider(Options{Backend: BackendProc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	netlinkProvider, err := NewStatsProvider(Options{Backend: BackendNetlink, TcpInfo: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	procStats, err := procProvider.GetStats("/", os.Getpid(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	netlinkStats, err := netlinkProvider.GetStats("/", os.Getpid(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if procStats.Tcp.Listen == 0 || procStats.Tcp.Listen != netlinkStats.Tcp.Listen {
+		t.Errorf("expect same listen sockets, proc %+v, netlink %+v", procStats.Tcp, netlinkStats.Tcp)
+	}
+
+	// Fall back to proc leaves no tcp info.
+	if len(netlinkStats.TcpInfo) > 0 {
+		port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+		if netlinkStats.TcpInfo[port] == nil || netlinkStats.TcpInfo[OutboundPort] == nil {
+			t.Errorf("expect tcp info of port %v and outbound connection, got %+v", port, netlinkStats.TcpInfo)
+		}
+		for _, backlog := range netlinkStats.Backlogs {
+			if strconv.Itoa(int(backlog.Port)) == port && backlog.Max == 0 {
+				t.Errorf("expect backlog limit of port %v, got %+v", port, backlog)
+			}
+		}
+		if netlinkStats.Somaxconn == 0 {
+			t.Errorf("expect somaxconn, got %+v", netlinkStats)
+		}
+	}
+
+	if _, err := NewStatsProvider(Options{Backend: BackendProc, TcpInfo: true}); err == nil {
+		t.Error("expect error for tcp info with proc backend")
+	}
+	if _, err := NewStatsProvider(Options{Backend: "ebpf"}); err == nil {
+		t.Error("expect error for unknown backend")
+	}
+}
+
+func TestRemoteCollector(t *testing.T) {
+	content := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0A00020F:1F90 0B6000C8:D431 01 00000000:00000000 02:000A7A7E 00000000     0        0 33515 1 0000000000000000 20 4 30 10 -1
+   1: 0A00020F:C350 0A6000C8:0CEA 01 00000000:00000000 02:000A7A7E 00000000     0        0 33512 1 0000000000000000 20 4 30 10 -1
+   2: 0A00020F:C352 0A6000C8:0CEA 01 00000000:00000000 02:000A7A7E 00000000     0        0 33513 1 0000000000000000 20 4 30 10 -1
+   3: 0A00020F:C354 0A6000C8:0CEA 08 00000000:00000000 02:000A7A7E 00000000     0        0 33514 1 0000000000000000 20 4 30 10 -1
+   4: 0A00020F:C356 0B6000C8:01BB 01 00000000:00000000 02:000A7A7E 00000000     0        0 33516 1 0000000000000000 20 4 30 10 -1
+   5: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 20931 1 0000000000000000 100 0 0 10 0
+`
+	file := writeProcFile(t, content)
+	defer os.RemoveAll(path.Dir(file))
+
+	remotes := newRemoteCollector()
+	if err := scanTcpSockets(file, remotes.add); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []RemoteStat{
+		{
+			IP:   "200.0.96.10",
+			Port: 3306,
+			Tcp:  TcpStat{Established: 2, CloseWait: 1},
+		},
+	}
+	if top := remotes.top(1); !reflect.DeepEqual(expect, top) {
+		t.Errorf("expect %+v, got %+v", expect, top)
+	}
+	// Connection accepted on 8080 is not counted although seen before the
+	// listening socket.
+	if top := remotes.top(10); len(top) != 2 || top[1].IP != "200.0.96.11" || top[1].Port != 443 {
+		t.Errorf("expect 2 remotes, got %+v", top)
+	}
+
+	var ip [16]byte
+	port, err := parseProcAddr("0000000000000000FFFF00000100007F:0050", &ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := net.IP(ip[:]).String(); addr != "127.0.0.1" || port != 80 {
+		t.Errorf("expect 127.0.0.1:80, got %v:%v", addr, port)
+	}
+}
+
+func TestOwnerCollector(t *testing.T) {
+	content := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:3A99 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1337        0 20931 1 0000000000000000 100 0 0 10 0
+   1: 0A00020F:3A99 0B6000C8:D431 01 00000000:00000000 02:000A7A7E 00000000  1337        0 33515 1 0000000000000000 20 4 30 10 -1
+   2: 0100007F:1F90 0100007F:C350 08 00000000:00000000 02:000A7A7E 00000000     0        0 33516 1 0000000000000000 20 4 30 10 -1
+   3: 0A00020F:C352 0A6000C8:0CEA 06 00000000:00000000 03:00000A7A 00000000     0        0 0 3 0000000000000000
+   4: 0A00020F:C354 0A6000C8:0CEA 01 00000000:00000000 02:000A7A7E 00000000     0        0 33517 1 0000000000000000 20 4 30 10 -1
+`
+	file := writeProcFile(t, content)
+	defer os.RemoveAll(path.Dir(file))
+
+	proxy := SocketOwner{PodUID: "1952d77", Container: "istio-proxy"}
+	app := SocketOwner{PodUID: "1952d77", Container: "app"}
+	owners := newOwnerCollector(SocketOwners{20931: proxy, 33515: proxy, 33516: app})
+	if err := scanTcpSockets(file, owners.add); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[SocketOwner]TcpStat{
+		proxy: {Listen: 1, Established: 1},
+		app:   {CloseWait: 1},
+	}
+	if stats := owners.list(); !reflect.DeepEqual(expect, stats) {
+		t.Errorf("expect %+v, got %+v", expect, stats)
+	}
+}
+
+func TestQueueCollector(t *testing.T) {
+	content := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:1F90 00000000:0000 0A 00000000:00000003 00:00000000 00000000     0        0 20931 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:1F90 00000000:0000 0A 00000000:00000001 00:00000000 00000000     0        0 20932 1 0000000000000000 100 0 0 10 0
+   2: 0A00020F:1F90 0B6000C8:D431 01 00000010:00000200 02:000A7A7E 00000000     0        0 33515 1 0000000000000000 20 4 30 10 -1
+   3: 0A00020F:C350 0A6000C8:0CEA 01 00000001:00000000 02:000A7A7E 00000000     0        0 33512 1 0000000000000000 20 4 30 10 -1
+`
+	file := writeProcFile(t, content)
+	defer os.RemoveAll(path.Dir(file))
+
+	queues := newQueueCollector()
+	if err := scanTcpSockets(file, queues.add); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []ListenBacklog{{Port: 8080, Queued: 4}}
+	if backlogs := queues.backlogs(); !reflect.DeepEqual(expect, backlogs) {
+		t.Errorf("expect %+v, got %+v", expect, backlogs)
+	}
+	if queues.recvQueued != 0x200 || queues.sendQueued != 0x11 {
+		t.Errorf("expect queued bytes 512/17, got %v/%v", queues.recvQueued, queues.sendQueued)
+	}
+}
+
+func TestSomaxconnCache(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "kube-extra-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// pid 1 and 2 share a network namespace, reading pid 3 fails.
+	netns := map[int]string{1: "net:[4026532200]", 2: "net:[4026532200]", 3: "net:[4026532300]"}
+	for pid, id := range netns {
+		dir := path.Join(tmpDir, "proc", strconv.Itoa(pid), "ns")
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(id, path.Join(dir, "net")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reads := 0
+	cache := newSomaxconnCache()
+	cache.read = func(rootFs string, pid int) (uint64, error) {
+		reads++
+		if pid == 3 {
+			return 0, os.ErrNotExist
+		}
+		return 4096, nil
+	}
+	for _, pid := range []int{1, 2, 3, 3} {
+		expect := uint64(4096)
+		if pid == 3 {
+			expect = 0
+		}
+		if v := cache.get(tmpDir, pid); v != expect {
+			t.Errorf("expect somaxconn %v of pid %v, got %v", expect, pid, v)
+		}
+	}
+	if reads != 2 {
+		t.Errorf("expect every namespace read once, got %v reads", reads)
+	}
+}
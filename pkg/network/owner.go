@@ -0,0 +1,50 @@
+package network
+
+// SocketOwner is who opened a socket. Command is the name of the process,
+// empty unless sockets are attributed to processes.
+type SocketOwner struct {
+	PodUID    string
+	Container string
+	Command   string
+}
+
+// SocketOwners maps inodes of sockets to their owners.
+type SocketOwners map[uint64]SocketOwner
+
+// ownerCollector counts tcp connections by owner of sockets, sockets of
+// unknown owners are skipped.
+type ownerCollector struct {
+	owners SocketOwners
+	stats  map[SocketOwner]*TcpStat
+}
+
+func newOwnerCollector(owners SocketOwners) *ownerCollector {
+	return &ownerCollector{
+		owners: owners,
+		stats:  map[SocketOwner]*TcpStat{},
+	}
+}
+
+func (c *ownerCollector) add(s *tcpSocket) {
+	if s.inode == 0 {
+		return
+	}
+	owner, ok := c.owners[s.inode]
+	if !ok {
+		return
+	}
+	stat, ok := c.stats[owner]
+	if !ok {
+		stat = &TcpStat{}
+		c.stats[owner] = stat
+	}
+	stat.count(s.state)
+}
+
+func (c *ownerCollector) list() map[SocketOwner]TcpStat {
+	stats := make(map[SocketOwner]TcpStat, len(c.stats))
+	for owner, stat := range c.stats {
+		stats[owner] = *stat
+	}
+	return stats
+}
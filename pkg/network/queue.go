@@ -0,0 +1,71 @@
+package network
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ListenBacklog is the accept queue of a tcp listening port, sockets of ipv4,
+// ipv6 and SO_REUSEPORT on the same port are summed.
+type ListenBacklog struct {
+	Port uint16
+	// Connections established but not accepted yet
+	Queued uint64
+	// Limit of Queued, 0 if unknown. Only netlink backend reports it.
+	Max uint64
+}
+
+// queueCollector sums socket queues while walking sockets.
+type queueCollector struct {
+	listens    map[uint16]*ListenBacklog
+	recvQueued uint64
+	sendQueued uint64
+}
+
+func newQueueCollector() *queueCollector {
+	return &queueCollector{listens: map[uint16]*ListenBacklog{}}
+}
+
+func (c *queueCollector) add(s *tcpSocket) {
+	if s.state != tcpListen {
+		c.recvQueued += uint64(s.rxQueue)
+		c.sendQueued += uint64(s.txQueue)
+		return
+	}
+	backlog, ok := c.listens[s.localPort]
+	if !ok {
+		backlog = &ListenBacklog{Port: s.localPort}
+		c.listens[s.localPort] = backlog
+	}
+	backlog.Queued += uint64(s.rxQueue)
+	backlog.Max += uint64(s.txQueue)
+}
+
+// backlogs returns accept queues sorted by port.
+func (c *queueCollector) backlogs() []ListenBacklog {
+	backlogs := make([]ListenBacklog, 0, len(c.listens))
+	for _, backlog := range c.listens {
+		backlogs = append(backlogs, *backlog)
+	}
+	sort.Slice(backlogs, func(i, j int) bool { return backlogs[i].Port < backlogs[j].Port })
+	return backlogs
+}
+
+// parseProcQueues parses tx_queue:rx_queue column of proc tables.
+func parseProcQueues(queues string) (uint32, uint32, error) {
+	i := strings.Index(queues, ":")
+	if i < 0 {
+		return 0, 0, fmt.Errorf("invalid queues %v", queues)
+	}
+	tx, err := strconv.ParseUint(queues[:i], 16, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid queues %v: %v", queues, err)
+	}
+	rx, err := strconv.ParseUint(queues[i+1:], 16, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid queues %v: %v", queues, err)
+	}
+	return uint32(tx), uint32(rx), nil
+}
@@ -0,0 +1,145 @@
+package network
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// RemoteStat counts outbound tcp connections of a pod to a remote endpoint by
+// state.
+type RemoteStat struct {
+	IP   string
+	Port uint16
+	Tcp  TcpStat
+}
+
+type remoteKey struct {
+	ip   [net.IPv6len]byte
+	port uint16
+}
+
+// remoteSocketKey is remote endpoint of connections on a local port.
+type remoteSocketKey struct {
+	remoteKey
+	localPort uint16
+}
+
+// remoteCollector aggregates outbound tcp connections by remote endpoint
+// while walking sockets. Connections accepted on listening ports are left
+// out, their remote ports are ephemeral ports of clients. Local ports are
+// resolved to listening ports after all sockets are seen.
+type remoteCollector struct {
+	listenPorts map[uint16]bool
+	sockets     map[remoteSocketKey]*TcpStat
+}
+
+func newRemoteCollector() *remoteCollector {
+	return &remoteCollector{
+		listenPorts: map[uint16]bool{},
+		sockets:     map[remoteSocketKey]*TcpStat{},
+	}
+}
+
+func (c *remoteCollector) add(s *tcpSocket) {
+	if s.state == tcpListen {
+		c.listenPorts[s.localPort] = true
+		return
+	}
+	if s.remotePort == 0 {
+		return
+	}
+	key := remoteSocketKey{
+		remoteKey: remoteKey{ip: s.remoteIP, port: s.remotePort},
+		localPort: s.localPort,
+	}
+	stat, ok := c.sockets[key]
+	if !ok {
+		stat = &TcpStat{}
+		c.sockets[key] = stat
+	}
+	stat.count(s.state)
+}
+
+// outbound aggregates connections not on listening ports by remote endpoint.
+func (c *remoteCollector) outbound() map[remoteKey]*TcpStat {
+	remotes := map[remoteKey]*TcpStat{}
+	for key, stat := range c.sockets {
+		if c.listenPorts[key.localPort] {
+			continue
+		}
+		remote, ok := remotes[key.remoteKey]
+		if !ok {
+			remote = &TcpStat{}
+			remotes[key.remoteKey] = remote
+		}
+		remote.Add(stat)
+	}
+	return remotes
+}
+
+// all returns all remote endpoints sorted by connections.
+func (c *remoteCollector) all() []RemoteStat {
+	remotes := c.outbound()
+	stats := make([]RemoteStat, 0, len(remotes))
+	for key, stat := range remotes {
+		stats = append(stats, RemoteStat{
+			IP:   net.IP(key.ip[:]).String(),
+			Port: key.port,
+			Tcp:  *stat,
+		})
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		ti, tj := stats[i].Tcp.total(), stats[j].Tcp.total()
+		if ti != tj {
+			return ti > tj
+		}
+		if stats[i].IP != stats[j].IP {
+			return stats[i].IP < stats[j].IP
+		}
+		return stats[i].Port < stats[j].Port
+	})
+	return stats
+}
+
+// top returns at most n remote endpoints with the most connections.
+func (c *remoteCollector) top(n int) []RemoteStat {
+	stats := c.all()
+	if len(stats) > n {
+		stats = stats[:n]
+	}
+	return stats
+}
+
+// parseProcAddr parses address in proc tables, e.g. 0100007F:0050 for tcp
+// or 00000000000000000000000001000000:0050 for tcp6. IP is stored in 16-byte
+// form, addresses are printed as 32-bit words in host byte order.
+func parseProcAddr(addr string, ip *[net.IPv6len]byte) (uint16, error) {
+	port, err := parseProcPort(addr)
+	if err != nil {
+		return 0, err
+	}
+
+	i := strings.LastIndex(addr, ":")
+	if i != 2*net.IPv4len && i != 2*net.IPv6len {
+		return 0, fmt.Errorf("invalid address %v", addr)
+	}
+	var raw [net.IPv6len]byte
+	n, err := hex.Decode(raw[:], []byte(addr[:i]))
+	if err != nil {
+		return 0, fmt.Errorf("invalid address %v: %v", addr, err)
+	}
+	for w := 0; w < n; w += 4 {
+		raw[w], raw[w+1], raw[w+2], raw[w+3] = raw[w+3], raw[w+2], raw[w+1], raw[w]
+	}
+
+	if n == net.IPv4len {
+		copy(ip[:], net.IPv4(raw[0], raw[1], raw[2], raw[3]))
+	} else {
+		copy(ip[:], raw[:])
+	}
+	return port, nil
+}
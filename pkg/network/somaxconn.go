@@ -0,0 +1,78 @@
+package network
+
+import (
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/caicloud/nirvana/log"
+)
+
+// somaxconnTTL is how long somaxconn of a network namespace is cached, it is
+// set by sysctls of pod on creation and rarely changes afterwards.
+const somaxconnTTL = 5 * time.Minute
+
+type somaxconnEntry struct {
+	value   uint64
+	expires time.Time
+}
+
+// somaxconnCache caches somaxconn by network namespace, since reading it
+// enters the namespace with a locked thread.
+type somaxconnCache struct {
+	read func(rootFs string, pid int) (uint64, error)
+
+	lock    sync.Mutex
+	entries map[string]somaxconnEntry
+	// permissionOnce warns once if somaxconn is not readable.
+	permissionOnce sync.Once
+}
+
+func newSomaxconnCache() *somaxconnCache {
+	return &somaxconnCache{
+		read:    readSomaxconn,
+		entries: map[string]somaxconnEntry{},
+	}
+}
+
+// get returns somaxconn of the network namespace of pid, 0 if unknown.
+func (c *somaxconnCache) get(rootFs string, pid int) uint64 {
+	netns, err := os.Readlink(path.Join(rootFs, "proc", strconv.Itoa(pid), "ns/net"))
+	if err != nil {
+		log.Warningf("Err get network namespace of pid %v, somaxconn is not reported: %v", pid, err)
+		return 0
+	}
+
+	now := time.Now()
+	c.lock.Lock()
+	entry, ok := c.entries[netns]
+	c.lock.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.value
+	}
+
+	// Failures are cached too, they'd fail again until namespace is gone.
+	value, err := c.read(rootFs, pid)
+	if err != nil {
+		if os.IsPermission(err) {
+			c.permissionOnce.Do(func() {
+				log.Warningf("Lacks privileges to enter network namespaces, somaxconn is not reported: %v", err)
+			})
+		} else {
+			log.Warningf("Err get somaxconn from pid %v: %v", pid, err)
+		}
+		value = 0
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for ns, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, ns)
+		}
+	}
+	c.entries[netns] = somaxconnEntry{value: value, expires: now.Add(somaxconnTTL)}
+	return value
+}
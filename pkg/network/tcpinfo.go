@@ -0,0 +1,136 @@
+package network
+
+import (
+	"strconv"
+)
+
+// OutboundPort is the port label of connections whose local port is not
+// listened on by the pod, i.e. connections initiated by the pod.
+const OutboundPort = "outbound"
+
+// Histogram is a cumulative histogram, Buckets maps upper bounds to the count
+// of observations less than or equal to them.
+type Histogram struct {
+	Count   uint64
+	Sum     float64
+	Buckets map[float64]uint64
+}
+
+func newHistogram(bounds []float64) Histogram {
+	h := Histogram{Buckets: make(map[float64]uint64, len(bounds))}
+	for _, b := range bounds {
+		h.Buckets[b] = 0
+	}
+	return h
+}
+
+func (h *Histogram) observe(v float64) {
+	h.Count++
+	h.Sum += v
+	for b := range h.Buckets {
+		if v <= b {
+			h.Buckets[b]++
+		}
+	}
+}
+
+var (
+	rttBuckets     = []float64{.0001, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+	cwndBuckets    = []float64{1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 1024}
+	segmentBuckets = []float64{0, 1, 2, 4, 8, 16, 32, 64, 128, 256}
+)
+
+// TcpInfoStat holds distributions of kernel tcp_info of connections sharing
+// a local listening port.
+type TcpInfoStat struct {
+	// Smoothed round trip time in seconds
+	Rtt Histogram
+	// Round trip time variance in seconds
+	RttVar Histogram
+	// Congestion window in segments
+	Cwnd Histogram
+	// Total retransmitted segments of connection
+	Retrans Histogram
+	// Segments sent but not acknowledged yet
+	Unacked Histogram
+	// Segments considered lost
+	Lost Histogram
+}
+
+func newTcpInfoStat() *TcpInfoStat {
+	return &TcpInfoStat{
+		Rtt:     newHistogram(rttBuckets),
+		RttVar:  newHistogram(rttBuckets),
+		Cwnd:    newHistogram(cwndBuckets),
+		Retrans: newHistogram(segmentBuckets),
+		Unacked: newHistogram(segmentBuckets),
+		Lost:    newHistogram(segmentBuckets),
+	}
+}
+
+// tcpInfo is the subset of struct tcp_info exported.
+type tcpInfo struct {
+	// rtt and rttVar are in microseconds.
+	rtt          uint32
+	rttVar       uint32
+	cwnd         uint32
+	totalRetrans uint32
+	unacked      uint32
+	lost         uint32
+}
+
+type tcpInfoSample struct {
+	localPort uint16
+	info      tcpInfo
+}
+
+// tcpInfoCollector gathers tcp_info of connections while walking sockets,
+// local ports are resolved to listening ports after all sockets are seen.
+type tcpInfoCollector struct {
+	listenPorts map[uint16]bool
+	samples     []tcpInfoSample
+}
+
+func newTcpInfoCollector() *tcpInfoCollector {
+	return &tcpInfoCollector{listenPorts: map[uint16]bool{}}
+}
+
+func (c *tcpInfoCollector) add(s *tcpSocket) {
+	if s.state == tcpListen {
+		c.listenPorts[s.localPort] = true
+		return
+	}
+	if s.info == nil {
+		return
+	}
+	c.samples = append(c.samples, tcpInfoSample{
+		localPort: s.localPort,
+		info:      *s.info,
+	})
+}
+
+// stats returns distributions keyed by listening port or OutboundPort.
+func (c *tcpInfoCollector) stats() map[string]*TcpInfoStat {
+	stats := map[string]*TcpInfoStat{}
+	for i := range c.samples {
+		sample := &c.samples[i]
+
+		port := OutboundPort
+		if c.listenPorts[sample.localPort] {
+			port = strconv.Itoa(int(sample.localPort))
+		}
+		stat, ok := stats[port]
+		if !ok {
+			stat = newTcpInfoStat()
+			stats[port] = stat
+		}
+
+		stat.Rtt.observe(float64(sample.info.rtt) / 1e6)
+		stat.RttVar.observe(float64(sample.info.rttVar) / 1e6)
+		stat.Cwnd.observe(float64(sample.info.cwnd))
+		stat.Retrans.observe(float64(sample.info.totalRetrans))
+		stat.Unacked.observe(float64(sample.info.unacked))
+		stat.Lost.observe(float64(sample.info.lost))
+	}
+	return stats
+}
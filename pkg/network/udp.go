@@ -0,0 +1,87 @@
+package network
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+type UdpStat struct {
+	// Count of UDP sockets that are only bound to a local address
+	Bound uint64
+	// Count of UDP sockets that are connected to a remote address
+	Connected uint64
+	// Sum of datagrams dropped by all UDP sockets
+	Drops uint64
+}
+
+// udpStatsFromProc counts udp sockets, bound is called with the local port of
+// every socket not connected.
+func udpStatsFromProc(rootFs string, pid int, file string, bound func(port uint16)) (UdpStat, error) {
+	udpStatsFile := path.Join(rootFs, "proc", strconv.Itoa(pid), file)
+
+	udpStats, err := scanUdpStats(udpStatsFile, bound)
+	if err != nil {
+		return udpStats, fmt.Errorf("couldn't read udp stats: %v", err)
+	}
+
+	return udpStats, nil
+}
+
+// scanUdpStats reads udpStatsFile line by line, so tables of huge size are
+// never held in memory.
+func scanUdpStats(udpStatsFile string, bound func(port uint16)) (UdpStat, error) {
+	var stats UdpStat
+
+	f, err := os.Open(udpStatsFile)
+	if err != nil {
+		return stats, fmt.Errorf("failure opening %s: %v", udpStatsFile, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+
+	scanner.Split(bufio.ScanLines)
+
+	// Discard header line
+	if b := scanner.Scan(); !b {
+		return stats, scanner.Err()
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		fields := strings.Fields(line)
+		// Format: sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode ref pointer drops
+		if len(fields) < 13 {
+			return stats, fmt.Errorf("invalid UDP stats line: %v", line)
+		}
+
+		switch fields[3] {
+		case "01": // ESTABLISHED
+			stats.Connected++
+		case "07": // CLOSE
+			stats.Bound++
+			if bound != nil {
+				port, err := parseProcPort(fields[1])
+				if err != nil {
+					return stats, fmt.Errorf("invalid UDP stats line %v: %v", line, err)
+				}
+				bound(port)
+			}
+		default:
+			return stats, fmt.Errorf("invalid UDP stats line: %v", line)
+		}
+
+		drops, err := strconv.ParseUint(fields[12], 10, 64)
+		if err != nil {
+			return stats, fmt.Errorf("invalid UDP drops in line %v: %v", line, err)
+		}
+		stats.Drops += drops
+	}
+
+	return stats, nil
+}
@@ -18,24 +18,35 @@ import (
 
 type Lister interface {
 	List() ([]*v1.Pod, error)
+	// AddEventHandler notifies handler of pods added, updated and deleted,
+	// handler added later is notified of existing pods as added.
+	AddEventHandler(handler cache.ResourceEventHandler)
 }
 
 type wrappedPodLister struct {
 	listers.PodLister
+	informer cache.SharedIndexInformer
 }
 
 func (l wrappedPodLister) List() ([]*v1.Pod, error) {
 	return l.PodLister.List(labels.Everything())
 }
 
+func (l wrappedPodLister) AddEventHandler(handler cache.ResourceEventHandler) {
+	l.informer.AddEventHandler(handler)
+}
+
 // NewLister creates a lister to list pods on local node.
 func NewLister(ctx context.Context, kubeClient kubernetes.Interface, node string) Lister {
 	lw := createPodListWatch(kubeClient, node)
-	indexer, reflector := cache.NewNamespaceKeyedIndexerAndReflector(lw, &v1.Pod{}, 5*time.Minute)
+	informer := cache.NewSharedIndexInformer(lw, &v1.Pod{}, 5*time.Minute, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
 
-	go reflector.Run(ctx.Done())
+	go informer.Run(ctx.Done())
 
-	return wrappedPodLister{listers.NewPodLister(indexer)}
+	return wrappedPodLister{
+		PodLister: listers.NewPodLister(informer.GetIndexer()),
+		informer:  informer,
+	}
 }
 
 func fieldsSelector(nodeName string) fields.Selector {
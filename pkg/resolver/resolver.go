@@ -0,0 +1,237 @@
+package resolver
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Kinds of objects an address is resolved to.
+const (
+	KindPod      = "pod"
+	KindService  = "service"
+	KindNode     = "node"
+	KindExternal = "external"
+)
+
+// Endpoint is the Kubernetes object owning an address.
+type Endpoint struct {
+	// Kind is one of KindPod, KindService, KindNode and KindExternal.
+	Kind      string
+	Namespace string
+	Workload  string
+	Service   string
+	Node      string
+}
+
+// Resolver maps IP addresses to Kubernetes objects.
+type Resolver interface {
+	Resolve(ip string) Endpoint
+}
+
+const ipIndex = "ip"
+
+type informerResolver struct {
+	services  cache.Indexer
+	endpoints cache.Indexer
+	pods      cache.Indexer
+	nodes     cache.Indexer
+}
+
+// New creates a resolver watching Services, Endpoints, Pods and Nodes of
+// cluster.
+func New(ctx context.Context, kubeClient kubernetes.Interface) Resolver {
+	return &informerResolver{
+		services:  runIndexer(ctx, kubeClient, "services", &v1.Service{}, serviceIPs),
+		endpoints: runIndexer(ctx, kubeClient, "endpoints", &v1.Endpoints{}, endpointsIPs),
+		pods:      runIndexer(ctx, kubeClient, "pods", &v1.Pod{}, podIPs),
+		nodes:     runIndexer(ctx, kubeClient, "nodes", &v1.Node{}, nodeIPs),
+	}
+}
+
+func runIndexer(ctx context.Context, kubeClient kubernetes.Interface, resource string, objType runtime.Object, ips func(obj interface{}) []string) cache.Indexer {
+	lw := cache.NewListWatchFromClient(kubeClient.CoreV1().RESTClient(), resource, v1.NamespaceAll, fields.Everything())
+	indexer := newIPIndexer(ips)
+	reflector := cache.NewReflector(lw, objType, indexer, 5*time.Minute)
+
+	go reflector.Run(ctx.Done())
+
+	return indexer
+}
+
+func newIPIndexer(ips func(obj interface{}) []string) cache.Indexer {
+	return cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{
+		ipIndex: func(obj interface{}) ([]string, error) {
+			return ips(obj), nil
+		},
+	})
+}
+
+func serviceIPs(obj interface{}) []string {
+	svc, ok := obj.(*v1.Service)
+	if !ok || svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == v1.ClusterIPNone {
+		return nil
+	}
+	return []string{svc.Spec.ClusterIP}
+}
+
+func endpointsIPs(obj interface{}) []string {
+	ep, ok := obj.(*v1.Endpoints)
+	if !ok {
+		return nil
+	}
+	ips := []string{}
+	for _, subset := range ep.Subsets {
+		for _, addr := range subset.Addresses {
+			ips = append(ips, addr.IP)
+		}
+		for _, addr := range subset.NotReadyAddresses {
+			ips = append(ips, addr.IP)
+		}
+	}
+	return ips
+}
+
+// podIPs leaves out hostNetwork pods, which have ips of nodes, and pods
+// finished, whose ips may be reused.
+func podIPs(obj interface{}) []string {
+	pod, ok := obj.(*v1.Pod)
+	if !ok || pod.Spec.HostNetwork || pod.Status.PodIP == "" {
+		return nil
+	}
+	if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return nil
+	}
+	return []string{pod.Status.PodIP}
+}
+
+func nodeIPs(obj interface{}) []string {
+	node, ok := obj.(*v1.Node)
+	if !ok {
+		return nil
+	}
+	ips := []string{}
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == v1.NodeInternalIP || addr.Type == v1.NodeExternalIP {
+			ips = append(ips, addr.Address)
+		}
+	}
+	return ips
+}
+
+// Resolve looks up ip in Services, Endpoints, Pods and Nodes in order,
+// addresses matching nothing are external.
+func (r *informerResolver) Resolve(ip string) Endpoint {
+	if svc, ok := firstByIP(r.services, ip).(*v1.Service); ok {
+		return Endpoint{
+			Kind:      KindService,
+			Namespace: svc.Namespace,
+			Service:   svc.Name,
+		}
+	}
+
+	if ep, ok := firstByIP(r.endpoints, ip).(*v1.Endpoints); ok {
+		endpoint := Endpoint{
+			Kind:      KindPod,
+			Namespace: ep.Namespace,
+			Service:   ep.Name,
+		}
+		if addr := findAddress(ep, ip); addr != nil {
+			if addr.TargetRef != nil && addr.TargetRef.Kind == "Pod" {
+				obj, exists, _ := r.pods.GetByKey(ep.Namespace + "/" + addr.TargetRef.Name)
+				if pod, ok := obj.(*v1.Pod); exists && ok {
+					endpoint.Workload = workloadName(pod)
+				}
+			}
+			if addr.NodeName != nil {
+				endpoint.Node = *addr.NodeName
+			}
+		}
+		return endpoint
+	}
+
+	// Pods backing no Service, e.g. clients and jobs.
+	if pod, ok := firstByIP(r.pods, ip).(*v1.Pod); ok {
+		return Endpoint{
+			Kind:      KindPod,
+			Namespace: pod.Namespace,
+			Workload:  workloadName(pod),
+			Node:      pod.Spec.NodeName,
+		}
+	}
+
+	if node, ok := firstByIP(r.nodes, ip).(*v1.Node); ok {
+		return Endpoint{
+			Kind: KindNode,
+			Node: node.Name,
+		}
+	}
+
+	return Endpoint{Kind: KindExternal}
+}
+
+// firstByIP returns the object with smallest key among objects owning ip, so
+// that an address shared by several objects is resolved stably.
+func firstByIP(indexer cache.Indexer, ip string) interface{} {
+	objs, err := indexer.ByIndex(ipIndex, ip)
+	if err != nil || len(objs) == 0 {
+		return nil
+	}
+	keys := make([]string, len(objs))
+	for i, obj := range objs {
+		keys[i], _ = cache.MetaNamespaceKeyFunc(obj)
+	}
+	sort.Sort(byKey{keys, objs})
+	return objs[0]
+}
+
+type byKey struct {
+	keys []string
+	objs []interface{}
+}
+
+func (b byKey) Len() int           { return len(b.keys) }
+func (b byKey) Less(i, j int) bool { return b.keys[i] < b.keys[j] }
+func (b byKey) Swap(i, j int) {
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+	b.objs[i], b.objs[j] = b.objs[j], b.objs[i]
+}
+
+func findAddress(ep *v1.Endpoints, ip string) *v1.EndpointAddress {
+	for i := range ep.Subsets {
+		subset := &ep.Subsets[i]
+		for j := range subset.Addresses {
+			if subset.Addresses[j].IP == ip {
+				return &subset.Addresses[j]
+			}
+		}
+		for j := range subset.NotReadyAddresses {
+			if subset.NotReadyAddresses[j].IP == ip {
+				return &subset.NotReadyAddresses[j]
+			}
+		}
+	}
+	return nil
+}
+
+// workloadName is name of the controller owning pod. Deployments own pods
+// through ReplicaSets named <deployment>-<pod-template-hash>. Pods without a
+// controller are workloads themselves, as are static pods owned by Nodes.
+func workloadName(pod *v1.Pod) string {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil || ref.Kind == "Node" {
+		return pod.Name
+	}
+	if hash := pod.Labels["pod-template-hash"]; ref.Kind == "ReplicaSet" && hash != "" {
+		return strings.TrimSuffix(ref.Name, "-"+hash)
+	}
+	return ref.Name
+}
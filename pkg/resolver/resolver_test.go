@@ -0,0 +1,100 @@
+package resolver
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestResolve(t *testing.T) {
+	nodeName := "node-1"
+	r := &informerResolver{
+		services:  newIPIndexer(serviceIPs),
+		endpoints: newIPIndexer(endpointsIPs),
+		pods:      newIPIndexer(podIPs),
+		nodes:     newIPIndexer(nodeIPs),
+	}
+	r.services.Add(&v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysql", Namespace: "db"},
+		Spec:       v1.ServiceSpec{ClusterIP: "10.96.0.20"},
+	})
+	r.endpoints.Add(&v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "mysql", Namespace: "db"},
+		Subsets: []v1.EndpointSubset{
+			{
+				Addresses: []v1.EndpointAddress{
+					{
+						IP:        "172.16.1.5",
+						NodeName:  &nodeName,
+						TargetRef: &v1.ObjectReference{Kind: "Pod", Name: "mysql-0"},
+					},
+				},
+			},
+		},
+	})
+	r.pods.Add(newPod("db", "mysql-0", "172.16.1.5", "StatefulSet", "mysql", nil))
+	// Pods backing no Service.
+	r.pods.Add(newPod("batch", "report-1600000000-x2k4z", "172.16.1.6", "Job", "report-1600000000", nil))
+	r.pods.Add(newPod("web", "nginx-7db9fccd9b-x2k4z", "172.16.1.7", "ReplicaSet", "nginx-7db9fccd9b",
+		map[string]string{"pod-template-hash": "7db9fccd9b"}))
+	finished := newPod("batch", "report-1500000000-b6c2z", "172.16.1.8", "Job", "report-1500000000", nil)
+	finished.Status.Phase = v1.PodSucceeded
+	r.pods.Add(finished)
+	r.nodes.Add(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: nodeName},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "192.168.0.10"}},
+		},
+	})
+
+	cases := map[string]Endpoint{
+		"10.96.0.20":   {Kind: KindService, Namespace: "db", Service: "mysql"},
+		"172.16.1.5":   {Kind: KindPod, Namespace: "db", Workload: "mysql", Service: "mysql", Node: nodeName},
+		"172.16.1.6":   {Kind: KindPod, Namespace: "batch", Workload: "report-1600000000", Node: nodeName},
+		"172.16.1.7":   {Kind: KindPod, Namespace: "web", Workload: "nginx", Node: nodeName},
+		"172.16.1.8":   {Kind: KindExternal},
+		"192.168.0.10": {Kind: KindNode, Node: nodeName},
+		"8.8.8.8":      {Kind: KindExternal},
+	}
+	for ip, expect := range cases {
+		if got := r.Resolve(ip); got != expect {
+			t.Errorf("%s: expect %+v, got %+v", ip, expect, got)
+		}
+	}
+}
+
+func TestWorkloadName(t *testing.T) {
+	cases := []struct {
+		pod    *v1.Pod
+		expect string
+	}{
+		{newPod("web", "nginx-7db9fccd9b-x2k4z", "", "ReplicaSet", "nginx-7db9fccd9b",
+			map[string]string{"pod-template-hash": "7db9fccd9b"}), "nginx"},
+		{newPod("web", "nginx-legacy-x2k4z", "", "ReplicaSet", "nginx-legacy", nil), "nginx-legacy"},
+		{newPod("kube-system", "fluentd-kx8vl", "", "DaemonSet", "fluentd", nil), "fluentd"},
+		{newPod("db", "web-0", "", "StatefulSet", "web", nil), "web"},
+		{newPod("kube-system", "kube-apiserver-master", "", "Node", "master", nil), "kube-apiserver-master"},
+		{newPod("default", "debug", "", "", "", nil), "debug"},
+	}
+	for _, c := range cases {
+		if got := workloadName(c.pod); got != c.expect {
+			t.Errorf("%s: expect %s, got %s", c.pod.Name, c.expect, got)
+		}
+	}
+}
+
+// newPod creates a running pod on node-1 controlled by owner of kind, or no
+// controller if kind is empty.
+func newPod(namespace, name, ip, kind, owner string, labels map[string]string) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		Spec:       v1.PodSpec{NodeName: "node-1"},
+		Status:     v1.PodStatus{Phase: v1.PodRunning, PodIP: ip},
+	}
+	if kind != "" {
+		controller := true
+		pod.OwnerReferences = []metav1.OwnerReference{{Kind: kind, Name: owner, Controller: &controller}}
+	}
+	return pod
+}